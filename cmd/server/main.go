@@ -2,24 +2,31 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"flag"
 	"log"
 	"net/http"
+	"os"
 	"strings"
 
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/acceptHeaders"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/clientcredentials"
+	"github.com/speakeasy-api/speakeasy-api-test-service/internal/cookiesession"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/ecommerce"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/errors"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/eventstreams"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/jsonLines"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/method"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/middleware"
+	"github.com/speakeasy-api/speakeasy-api-test-service/internal/oidc"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/pagination"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/readonlywriteonly"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/reflect"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/responseHeaders"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/retries"
+	"github.com/speakeasy-api/speakeasy-api-test-service/internal/security"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/xNdJson"
 
 	"github.com/gorilla/mux"
@@ -27,16 +34,90 @@ import (
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/requestbody"
 )
 
-var bindArg = flag.String("b", ":8080", "Bind address")
+var (
+	bindArg            = flag.String("b", ":8080", "Bind address")
+	refreshRotationArg = flag.Bool("refresh-rotation", false, "Make OAuth2 refresh tokens single use by default")
+	jwtAlgArg          = flag.String("jwt-alg", auth.SigningAlgHS256, "Signing algorithm for issued JWTs (HS256, RS256, ES256 or EdDSA)")
+	tlsCertArg         = flag.String("tls-cert", "", "TLS certificate file, enables HTTPS together with -tls-key")
+	tlsKeyArg          = flag.String("tls-key", "", "TLS private key file")
+	oauth2ErrorURIArg  = flag.String("oauth2-error-uri", "", "Base URI returned as error_uri of OAuth2 errors, with the error code as fragment")
+	sessionTTLArg      = flag.Duration("oauth2-session-ttl", auth.TokenSessionTTL, "How long idle OAuth2 test sessions keep their token state")
+	customSecurityArg  = flag.String("custom-security", "", "JSON file of custom security schemes to register for /auth/customsecurity")
+	oauth2ConfigArg    = flag.String("oauth2-config", "", "JSON file of additional OAuth2 clients and users to register")
+	chaosArg           = flag.String("chaos", "", `JSON chaos settings applied to every request without a request-id header, e.g. {"error_rate": 0.1, "latency_p50_ms": 50, "latency_p99_ms": 500}`)
+	tlsClientCAArg     = flag.String("tls-client-ca", "", "CA bundle used to verify client certificates for tls_client_auth (any client certificate is accepted if unset)")
+)
 
 func main() {
 	flag.Parse()
 
+	auth.RefreshTokenRotation = *refreshRotationArg
+	auth.SigningAlgorithm = *jwtAlgArg
+	auth.ErrorURI = *oauth2ErrorURIArg
+	auth.TokenSessionTTL = *sessionTTLArg
+	if err := auth.ValidateSigningAlgorithm(auth.SigningAlgorithm); err != nil {
+		log.Fatal(err)
+	}
+	if *customSecurityArg != "" {
+		if err := auth.LoadCustomSecuritySchemes(*customSecurityArg); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *oauth2ConfigArg != "" {
+		if err := auth.LoadRegistry(*oauth2ConfigArg); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *chaosArg != "" {
+		var chaos middleware.ChaosSettings
+		if err := json.Unmarshal([]byte(*chaosArg), &chaos); err != nil {
+			log.Fatalf("invalid -chaos settings: %v", err)
+		}
+		middleware.RegisterFaultRule(middleware.FaultRule{ID: "chaos", Settings: middleware.FaultSettings{Chaos: &chaos}})
+	}
+
 	r := mux.NewRouter()
 	r.HandleFunc("/oauth2/token", auth.HandleOAuth2InspectToken).Methods(http.MethodGet)
 	r.HandleFunc("/oauth2/token", auth.HandleOAuth2).Methods(http.MethodPost)
+	r.HandleFunc("/.well-known/openid-configuration", auth.HandleOpenIDConfiguration).Methods(http.MethodGet)
+	r.HandleFunc("/.well-known/jwks.json", auth.HandleJWKS).Methods(http.MethodGet)
+	r.HandleFunc("/admin/oauth2/keys/rotate", auth.HandleRotateSigningKeys).Methods(http.MethodPost)
+	r.HandleFunc("/admin/oauth2/sessions", auth.HandleListTokenSessions).Methods(http.MethodGet)
+	r.HandleFunc("/admin/oauth2/sessions/{session}", auth.HandleGetTokenSession).Methods(http.MethodGet)
+	r.HandleFunc("/admin/oauth2/sessions/{session}", auth.HandleResetTokenSession).Methods(http.MethodDelete)
+	r.HandleFunc("/admin/oauth2/clock/{session}", auth.HandleGetClock).Methods(http.MethodGet)
+	r.HandleFunc("/admin/oauth2/clock/{session}", auth.HandleResetClock).Methods(http.MethodDelete)
+	r.HandleFunc("/admin/oauth2/clock/{session}/{action:freeze|unfreeze|advance|set}", auth.HandleUpdateClock).Methods(http.MethodPost)
+	r.HandleFunc("/admin/oauth2/clients", auth.HandleListClients).Methods(http.MethodGet)
+	r.HandleFunc("/admin/oauth2/clients/{client_id}", auth.HandleGetClient).Methods(http.MethodGet)
+	r.HandleFunc("/admin/oauth2/clients/{client_id}", auth.HandlePutClient).Methods(http.MethodPut)
+	r.HandleFunc("/admin/oauth2/clients/{client_id}", auth.HandleDeleteClient).Methods(http.MethodDelete)
+	r.HandleFunc("/admin/oauth2/clients/{client_id}/jwks", auth.HandleRegisterClientKeys).Methods(http.MethodPut)
+	r.HandleFunc("/admin/oauth2/users", auth.HandleListUsers).Methods(http.MethodGet)
+	r.HandleFunc("/admin/oauth2/users/{username}", auth.HandleGetUser).Methods(http.MethodGet)
+	r.HandleFunc("/admin/oauth2/users/{username}", auth.HandlePutUser).Methods(http.MethodPut)
+	r.HandleFunc("/admin/oauth2/users/{username}", auth.HandleDeleteUser).Methods(http.MethodDelete)
+	r.HandleFunc("/oauth2/register", auth.HandleOAuth2Register).Methods(http.MethodPost)
+	r.HandleFunc("/oauth2/introspect", auth.HandleOAuth2Introspect).Methods(http.MethodPost)
+	r.HandleFunc("/oauth2/revoke", auth.HandleOAuth2Revoke).Methods(http.MethodPost)
+	r.HandleFunc("/oauth2/device_authorization", auth.HandleOAuth2DeviceAuthorization).Methods(http.MethodPost)
+	r.HandleFunc("/oauth2/device", auth.HandleOAuth2DeviceVerification).Methods(http.MethodPost)
+	r.HandleFunc("/oauth2/authorize", auth.HandleOAuth2Authorize).Methods(http.MethodGet, http.MethodPost)
 	r.HandleFunc("/auth", auth.HandleAuth).Methods(http.MethodPost)
 	r.HandleFunc("/auth/customsecurity/{customSchemeType}", auth.HandleCustomAuth).Methods(http.MethodGet)
+	r.HandleFunc("/auth/sigv4", auth.HandleSigV4Auth)
+	r.HandleFunc("/admin/customsecurity", auth.HandleListCustomSecuritySchemes).Methods(http.MethodGet)
+	r.HandleFunc("/admin/customsecurity/{customSchemeType}", auth.HandlePutCustomSecurityScheme).Methods(http.MethodPut)
+	r.HandleFunc("/admin/customsecurity/{customSchemeType}", auth.HandleDeleteCustomSecurityScheme).Methods(http.MethodDelete)
+	r.HandleFunc("/admin/sigv4/keys/{access_key_id}", auth.HandlePutSigV4Key).Methods(http.MethodPut)
+	r.HandleFunc("/admin/faults/rules", middleware.HandleListFaultRules).Methods(http.MethodGet)
+	r.HandleFunc("/admin/faults/rules/{id}", middleware.HandlePutFaultRule).Methods(http.MethodPut)
+	r.HandleFunc("/admin/faults/rules/{id}", middleware.HandleDeleteFaultRule).Methods(http.MethodDelete)
+	r.HandleFunc("/admin/faults/sessions", middleware.HandleListFaultSessions).Methods(http.MethodGet)
+	r.HandleFunc("/admin/faults/sessions", middleware.HandleResetFaultSession).Methods(http.MethodDelete)
+	r.HandleFunc("/admin/faults/sessions/{id}", middleware.HandleGetFaultSession).Methods(http.MethodGet)
+	r.HandleFunc("/admin/faults/sessions/{id}", middleware.HandleResetFaultSession).Methods(http.MethodDelete)
 	r.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("pong"))
 	}).Methods(http.MethodGet)
@@ -77,6 +158,9 @@ func main() {
 	r.HandleFunc("/clientcredentials/authenticatedrequest", clientcredentials.HandleAuthenticatedRequest).Methods(http.MethodPost)
 	r.HandleFunc("/clientcredentials/alt/token", clientcredentials.HandleTokenRequest).Methods(http.MethodPost)
 	r.HandleFunc("/clientcredentials/alt/authenticatedrequest", clientcredentials.HandleAuthenticatedRequest).Methods(http.MethodPost)
+	r.HandleFunc("/admin/clientcredentials/force401", clientcredentials.HandleForceUnauthorized).Methods(http.MethodPost)
+	r.HandleFunc("/session/login", auth.HandleSessionLogin).Methods(http.MethodPost)
+	r.HandleFunc("/session/logout", auth.HandleSessionLogout).Methods(http.MethodPost)
 	r.HandleFunc("/reflect", reflect.HandleReflect).Methods(http.MethodPost)
 	r.HandleFunc("/method/delete", method.HandleDelete).Methods(http.MethodDelete)
 	r.HandleFunc("/method/get", method.HandleGet).Methods(http.MethodGet)
@@ -89,12 +173,31 @@ func main() {
 
 	oauth2router := r.NewRoute().Subrouter()
 	oauth2router.Use(middleware.OAuth2)
+	oauth2router.HandleFunc("/userinfo", oidc.HandleUserInfo).Methods(http.MethodGet, http.MethodPost)
 	oauth2router.HandleFunc("/ecommerce/products", ecommerce.HandleListProducts).Methods(http.MethodGet)
 	oauth2router.HandleFunc("/ecommerce/products", ecommerce.HandleCreateProduct).Methods(http.MethodPost)
 	oauth2router.HandleFunc("/ecommerce/products/{id}", ecommerce.HandleFetchProduct).Methods(http.MethodGet)
 	oauth2router.HandleFunc("/ecommerce/products/{id}", ecommerce.HandleDeleteProduct).Methods(http.MethodDelete)
 	oauth2router.HandleFunc("/ecommerce/products/{id}/inventory", ecommerce.HandleUpdateProductStock).Methods(http.MethodPut)
 
+	sessionrouter := r.NewRoute().Subrouter()
+	sessionrouter.Use(middleware.CookieSession)
+	sessionrouter.HandleFunc("/session/me", cookiesession.HandleGetSession).Methods(http.MethodGet)
+	sessionrouter.HandleFunc("/session/me", cookiesession.HandleUpdateSession).Methods(http.MethodPut, http.MethodPatch)
+
+	// The security routes evaluate OpenAPI style security requirements:
+	// [apiKey AND basic] OR [oauth2(read)], optionally anonymous.
+	multiSecurity := []middleware.SecurityRequirement{
+		{"apiKey": nil, "basic": nil},
+		{"oauth2": {"read"}},
+	}
+	securityrouter := r.NewRoute().Subrouter()
+	securityrouter.Use(middleware.Security(multiSecurity...))
+	securityrouter.HandleFunc("/security/multi", security.HandleSecured).Methods(http.MethodGet, http.MethodPost)
+	optionalsecurityrouter := r.NewRoute().Subrouter()
+	optionalsecurityrouter.Use(middleware.Security(append(multiSecurity, middleware.SecurityRequirement{})...))
+	optionalsecurityrouter.HandleFunc("/security/optional", security.HandleSecured).Methods(http.MethodGet, http.MethodPost)
+
 	handler := middleware.Fault(r)
 	handler = middleware.Teapot(handler)
 
@@ -109,9 +212,47 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go auth.StartTokenDBCompaction(ctx)
+	go clientcredentials.StartTokenCompaction(ctx)
+
+	if *tlsCertArg != "" || *tlsKeyArg != "" {
+		server := &http.Server{
+			Addr:      bind,
+			Handler:   handler,
+			TLSConfig: tlsConfig(*tlsClientCAArg),
+		}
+
+		log.Printf("Listening on %s (TLS)\n", bind)
+		if err := server.ListenAndServeTLS(*tlsCertArg, *tlsKeyArg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	log.Printf("Listening on %s\n", bind)
 	if err := http.ListenAndServe(bind, handler); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// tlsConfig requests, but does not require, client certificates so that mutual
+// TLS client authentication can be tested alongside every other endpoint.
+func tlsConfig(clientCAFile string) *tls.Config {
+	if clientCAFile == "" {
+		return &tls.Config{ClientAuth: tls.RequestClientCert}
+	}
+
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		log.Fatalf("no certificates found in %s", clientCAFile)
+	}
+
+	return &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  pool,
+	}
+}
@@ -1,16 +1,47 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/brianvoe/gofakeit/v7"
 	"github.com/speakeasy-api/speakeasy-api-test-service/pkg/models"
 )
 
 var authError = errors.New("invalid auth")
 
-func checkAuth(req models.AuthRequest, r *http.Request) error {
+const digestNonceLifetime = 5 * time.Minute
+
+// challengeError is an authentication failure the client can recover from by
+// answering the WWW-Authenticate challenge.
+type challengeError struct {
+	challenge string
+	err       error
+}
+
+func (e *challengeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *challengeError) Unwrap() error {
+	return e.err
+}
+
+func checkAuth(req models.AuthRequest, r *http.Request, body []byte) error {
 	if req.BasicAuth != nil {
 		if err := checkBasicAuth(*req.BasicAuth, r); err != nil {
 			return err
@@ -23,6 +54,36 @@ func checkAuth(req models.AuthRequest, r *http.Request) error {
 		}
 	}
 
+	for _, queryAuth := range req.QueryAuth {
+		if err := checkQueryAuth(queryAuth, r); err != nil {
+			return err
+		}
+	}
+
+	for _, cookieAuth := range req.CookieAuth {
+		if err := checkCookieAuth(cookieAuth, r); err != nil {
+			return err
+		}
+	}
+
+	if req.BearerAuth != nil {
+		if err := checkBearerAuth(*req.BearerAuth, r); err != nil {
+			return err
+		}
+	}
+
+	if req.DigestAuth != nil {
+		if err := checkDigestAuth(*req.DigestAuth, r); err != nil {
+			return err
+		}
+	}
+
+	if req.HMACAuth != nil {
+		if err := checkHMACAuth(*req.HMACAuth, r, body); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -60,3 +121,229 @@ func checkHeaderAuth(headerAuth models.HeaderAuth, r *http.Request) error {
 
 	return nil
 }
+
+func checkQueryAuth(queryAuth models.APIKeyAuth, r *http.Request) error {
+	value := r.URL.Query().Get(queryAuth.Name)
+	if value == "" {
+		return fmt.Errorf("missing %s query parameter: %w", queryAuth.Name, authError)
+	}
+
+	if value != queryAuth.ExpectedValue {
+		return fmt.Errorf("invalid %s query parameter: %w", queryAuth.Name, authError)
+	}
+
+	return nil
+}
+
+func checkCookieAuth(cookieAuth models.APIKeyAuth, r *http.Request) error {
+	cookie, err := r.Cookie(cookieAuth.Name)
+	if err != nil {
+		return fmt.Errorf("missing %s cookie: %w", cookieAuth.Name, authError)
+	}
+
+	if cookie.Value != cookieAuth.ExpectedValue {
+		return fmt.Errorf("invalid %s cookie: %w", cookieAuth.Name, authError)
+	}
+
+	return nil
+}
+
+func checkBearerAuth(bearerAuth models.BearerAuth, r *http.Request) error {
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) < 7 || !strings.EqualFold(authHeader[:7], "Bearer ") {
+		return fmt.Errorf("missing Authorization header for Bearer Auth: %w", authError)
+	}
+
+	if authHeader[7:] != bearerAuth.Token {
+		return fmt.Errorf("invalid token for Bearer Auth: %w", authError)
+	}
+
+	return nil
+}
+
+type digestNonce struct {
+	expiresAt time.Time
+
+	mu sync.Mutex
+	// nc is the highest nonce count used with the nonce, to reject replays.
+	nc uint64
+}
+
+var digestNonces sync.Map
+
+func digestHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5":
+		return md5.New, nil
+	case "SHA-256":
+		return sha256.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm %s", algorithm)
+	}
+}
+
+func digestChallenge(realm, algorithm string, stale bool) string {
+	nonce := gofakeit.UUID()
+	digestNonces.Store(nonce, &digestNonce{expiresAt: time.Now().Add(digestNonceLifetime)})
+
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	challenge := fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=%s, nonce="%s", opaque="%s"`, realm, algorithm, nonce, realm)
+	if stale {
+		challenge += ", stale=true"
+	}
+
+	return challenge
+}
+
+// parseDigestParams parses the comma separated auth-params of a Digest
+// Authorization header.
+func parseDigestParams(params string) map[string]string {
+	parsed := map[string]string{}
+	for params != "" {
+		var name, value string
+		name, params, _ = strings.Cut(params, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		params = strings.TrimSpace(params)
+
+		if strings.HasPrefix(params, `"`) {
+			end := strings.Index(params[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, params = params[1:end+1], params[end+2:]
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+		}
+		parsed[name] = strings.TrimSpace(value)
+		params = strings.TrimLeft(params, " ,")
+	}
+
+	return parsed
+}
+
+func checkDigestAuth(digestAuth models.DigestAuth, r *http.Request) error {
+	realm := digestAuth.Realm
+	if realm == "" {
+		realm = "speakeasy-api-test-service"
+	}
+	newHash, err := digestHash(digestAuth.Algorithm)
+	if err != nil {
+		return err
+	}
+	challenge := func(stale bool, format string, args ...any) error {
+		return &challengeError{
+			challenge: digestChallenge(realm, digestAuth.Algorithm, stale),
+			err:       fmt.Errorf(format+": %w", append(args, authError)...),
+		}
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) < 7 || !strings.EqualFold(authHeader[:7], "Digest ") {
+		return challenge(false, "missing Authorization header for Digest Auth")
+	}
+	params := parseDigestParams(authHeader[7:])
+
+	v, ok := digestNonces.Load(params["nonce"])
+	if !ok {
+		return challenge(true, "unknown nonce for Digest Auth")
+	}
+	nonce := v.(*digestNonce)
+	if nonce.expiresAt.Before(time.Now()) {
+		digestNonces.Delete(params["nonce"])
+		return challenge(true, "expired nonce for Digest Auth")
+	}
+
+	if params["username"] != digestAuth.Username || params["realm"] != realm {
+		return challenge(false, "invalid username for Digest Auth")
+	}
+	if params["uri"] != r.URL.RequestURI() {
+		return challenge(false, "digest uri does not match the request")
+	}
+	if params["qop"] != "auth" {
+		return challenge(false, "qop must be auth for Digest Auth")
+	}
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil || params["cnonce"] == "" {
+		return challenge(false, "missing nc or cnonce for Digest Auth")
+	}
+
+	h := func(s string) string {
+		sum := newHash()
+		sum.Write([]byte(s))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+	ha1 := h(digestAuth.Username + ":" + realm + ":" + digestAuth.Password)
+	ha2 := h(r.Method + ":" + params["uri"])
+	expected := h(strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], ha2}, ":"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) != 1 {
+		return challenge(false, "invalid response for Digest Auth")
+	}
+
+	nonce.mu.Lock()
+	defer nonce.mu.Unlock()
+	if nc <= nonce.nc {
+		return challenge(false, "replayed nonce count for Digest Auth")
+	}
+	nonce.nc = nc
+
+	return nil
+}
+
+func hmacHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case "", "sha256":
+		return sha256.New, nil
+	case "sha1":
+		return sha1.New, nil
+	case "sha512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported HMAC algorithm %s", algorithm)
+	}
+}
+
+func checkHMACAuth(hmacAuth models.HMACAuth, r *http.Request, body []byte) error {
+	headerName := hmacAuth.HeaderName
+	if headerName == "" {
+		headerName = "X-Signature"
+	}
+	newHash, err := hmacHash(hmacAuth.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	signature := r.Header.Get(headerName)
+	if signature == "" {
+		return fmt.Errorf("missing %s header for HMAC Auth: %w", headerName, authError)
+	}
+	// Accept GitHub style "sha256=<signature>" values.
+	algorithm := strings.ToLower(hmacAuth.Algorithm)
+	if algorithm == "" {
+		algorithm = "sha256"
+	}
+	signature = strings.TrimPrefix(signature, algorithm+"=")
+
+	var decoded []byte
+	switch hmacAuth.Encoding {
+	case "", "hex":
+		decoded, err = hex.DecodeString(signature)
+	case "base64":
+		decoded, err = base64.StdEncoding.DecodeString(signature)
+	default:
+		return fmt.Errorf("unsupported HMAC encoding %s", hmacAuth.Encoding)
+	}
+	if err != nil {
+		return fmt.Errorf("malformed %s header for HMAC Auth: %w", headerName, authError)
+	}
+
+	mac := hmac.New(newHash, []byte(hmacAuth.Secret))
+	mac.Write([]byte(r.Method + "\n" + r.URL.Path + "\n"))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), decoded) {
+		return fmt.Errorf("invalid signature for HMAC Auth: %w", authError)
+	}
+
+	return nil
+}
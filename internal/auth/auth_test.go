@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func callAuth(body string, setup func(r *http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/auth?api_key=query-key", strings.NewReader(body))
+	setup(req)
+	w := httptest.NewRecorder()
+	HandleAuth(w, req)
+	return w
+}
+
+func TestHandleAuthAPIKeysAndBearer(t *testing.T) {
+	body := `{"queryAuth": [{"name": "api_key", "expectedValue": "query-key"}], "cookieAuth": [{"name": "session", "expectedValue": "cookie-key"}], "bearerAuth": {"token": "bearer-token"}}`
+
+	w := callAuth(body, func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: "session", Value: "cookie-key"})
+		r.Header.Set("Authorization", "Bearer bearer-token")
+	})
+	if w.Code != http.StatusOK {
+		t.Errorf("valid credentials: status = %v: %s", w.Code, w.Body.String())
+	}
+
+	w = callAuth(body, func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer bearer-token")
+	})
+	// Failed credentials respond with 500, only challenges with 401.
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("missing cookie: status = %v, want 500", w.Code)
+	}
+
+	w = callAuth(`{"basicAuth": {"username": "user", "password": "pass"}}`, func(r *http.Request) {
+		r.SetBasicAuth("user", "wrong")
+	})
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("invalid basic auth: status = %v, want 500", w.Code)
+	}
+}
+
+func TestHandleAuthDigest(t *testing.T) {
+	body := `{"digestAuth": {"username": "user", "password": "pass"}}`
+
+	w := callAuth(body, func(r *http.Request) {})
+	challenge := w.Header().Get("WWW-Authenticate")
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(challenge, "Digest ") {
+		t.Fatalf("unauthenticated request: status = %v, challenge = %q", w.Code, challenge)
+	}
+	params := parseDigestParams(challenge[len("Digest "):])
+
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	authorization := func(nc string) string {
+		ha1 := md5hex("user:" + params["realm"] + ":pass")
+		ha2 := md5hex("POST:/auth?api_key=query-key")
+		response := md5hex(strings.Join([]string{ha1, params["nonce"], nc, "cnonce", "auth", ha2}, ":"))
+		return fmt.Sprintf(`Digest username="user", realm="%s", nonce="%s", uri="/auth?api_key=query-key", qop=auth, nc=%s, cnonce="cnonce", response="%s", opaque="%s"`,
+			params["realm"], params["nonce"], nc, response, params["opaque"])
+	}
+
+	w = callAuth(body, func(r *http.Request) { r.Header.Set("Authorization", authorization("00000001")) })
+	if w.Code != http.StatusOK {
+		t.Fatalf("digest response: status = %v: %s", w.Code, w.Body.String())
+	}
+
+	w = callAuth(body, func(r *http.Request) { r.Header.Set("Authorization", authorization("00000001")) })
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed nonce count: status = %v, want 401", w.Code)
+	}
+}
+
+func TestHandleAuthHMAC(t *testing.T) {
+	body := `{"hmacAuth": {"secret": "hmac-secret"}}`
+
+	mac := hmac.New(sha256.New, []byte("hmac-secret"))
+	mac.Write([]byte("POST\n/auth\n" + body))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	w := callAuth(body, func(r *http.Request) { r.Header.Set("X-Signature", "sha256="+signature) })
+	if w.Code != http.StatusOK {
+		t.Errorf("valid signature: status = %v: %s", w.Code, w.Body.String())
+	}
+
+	w = callAuth(body, func(r *http.Request) { r.Header.Set("X-Signature", strings.Repeat("0", len(signature))) })
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("invalid signature: status = %v, want 500", w.Code)
+	}
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+func authorize(t *testing.T, params url.Values) *url.URL {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/oauth2/authorize?"+params.Encode(), nil)
+	w := httptest.NewRecorder()
+	HandleOAuth2Authorize(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("HandleOAuth2Authorize() status = %v, want %v: %s", w.Code, http.StatusFound, w.Body.String())
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect location: %v", err)
+	}
+
+	return location
+}
+
+func exchangeCode(form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	HandleOAuth2(w, req)
+	return w
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	sum := sha256.Sum256([]byte(testCodeVerifier))
+	s256Challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name                string
+		codeChallenge       string
+		codeChallengeMethod string
+		tokenRedirectURI    string
+		codeVerifier        string
+		wantStatus          int
+		wantError           string
+	}{
+		{
+			name:                "S256 challenge",
+			codeChallenge:       s256Challenge,
+			codeChallengeMethod: CodeChallengeMethodS256,
+			codeVerifier:        testCodeVerifier,
+			wantStatus:          http.StatusOK,
+		},
+		{
+			name:                "plain challenge",
+			codeChallenge:       testCodeVerifier,
+			codeChallengeMethod: CodeChallengeMethodPlain,
+			codeVerifier:        testCodeVerifier,
+			wantStatus:          http.StatusOK,
+		},
+		{
+			name:       "no challenge",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:                "wrong verifier",
+			codeChallenge:       s256Challenge,
+			codeChallengeMethod: CodeChallengeMethodS256,
+			codeVerifier:        strings.Repeat("a", 43),
+			wantStatus:          http.StatusBadRequest,
+			wantError:           string(ErrCodeInvalidGrant),
+		},
+		{
+			name:                "missing verifier",
+			codeChallenge:       s256Challenge,
+			codeChallengeMethod: CodeChallengeMethodS256,
+			wantStatus:          http.StatusBadRequest,
+			wantError:           string(ErrCodeInvalidGrant),
+		},
+		{
+			name:             "mismatched redirect uri",
+			tokenRedirectURI: "https://evil.example.com/callback",
+			wantStatus:       http.StatusBadRequest,
+			wantError:        string(ErrCodeInvalidGrant),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirectURI := "https://client.example.com/callback"
+
+			params := url.Values{}
+			params.Set("response_type", "code")
+			params.Set("client_id", "beezy")
+			params.Set("redirect_uri", redirectURI)
+			params.Set("state", "xyz")
+			if tt.codeChallenge != "" {
+				params.Set("code_challenge", tt.codeChallenge)
+				params.Set("code_challenge_method", tt.codeChallengeMethod)
+			}
+
+			location := authorize(t, params)
+			if got := location.Query().Get("state"); got != "xyz" {
+				t.Errorf("redirect state = %q, want %q", got, "xyz")
+			}
+			code := location.Query().Get("code")
+			if code == "" {
+				t.Fatalf("redirect is missing code: %s", location)
+			}
+
+			tokenRedirectURI := redirectURI
+			if tt.tokenRedirectURI != "" {
+				tokenRedirectURI = tt.tokenRedirectURI
+			}
+
+			form := url.Values{}
+			form.Set("grant_type", "authorization_code")
+			form.Set("client_id", "beezy")
+			form.Set("code", code)
+			form.Set("redirect_uri", tokenRedirectURI)
+			if tt.codeVerifier != "" {
+				form.Set("code_verifier", tt.codeVerifier)
+			}
+
+			w := exchangeCode(form)
+			if w.Code != tt.wantStatus {
+				t.Errorf("HandleOAuth2() status = %v, want %v: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantError != "" && !strings.Contains(w.Body.String(), tt.wantError) {
+				t.Errorf("HandleOAuth2() body = %s, want error %s", w.Body.String(), tt.wantError)
+			}
+
+			// A used code is rejected, as is a failed exchange when repeated.
+			w = exchangeCode(form)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), string(ErrCodeInvalidGrant)) {
+				t.Errorf("reused code: HandleOAuth2() status = %v, body = %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeBasicAuth(t *testing.T) {
+	redirectURI := "https://client.example.com/callback"
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "beezy")
+	params.Set("redirect_uri", redirectURI)
+	code := authorize(t, params).Query().Get("code")
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("beezy", "super-secret")
+	w := httptest.NewRecorder()
+	HandleOAuth2(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandleOAuth2() status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	// Without a client_id or client authentication the request is rejected.
+	w = exchangeCode(form)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), string(ErrCodeInvalidRequest)) {
+		t.Errorf("anonymous exchange: HandleOAuth2() status = %v, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAuthorizationCodeFailedExchangeKeepsCode(t *testing.T) {
+	sum := sha256.Sum256([]byte(testCodeVerifier))
+	redirectURI := "https://client.example.com/callback"
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "beezy")
+	params.Set("redirect_uri", redirectURI)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+	params.Set("code_challenge_method", CodeChallengeMethodS256)
+	code := authorize(t, params).Query().Get("code")
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("client_id", "beezy")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", strings.Repeat("a", 43))
+	if w := exchangeCode(form); w.Code != http.StatusBadRequest {
+		t.Fatalf("wrong verifier: HandleOAuth2() status = %v: %s", w.Code, w.Body.String())
+	}
+
+	form.Set("code_verifier", testCodeVerifier)
+	if w := exchangeCode(form); w.Code != http.StatusOK {
+		t.Errorf("HandleOAuth2() status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+}
+
+func TestAuthorizationCodeFlowIDToken(t *testing.T) {
+	redirectURI := "https://client.example.com/callback"
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", "beezy")
+	params.Set("redirect_uri", redirectURI)
+	params.Set("scope", "openid profile")
+	params.Set("nonce", "n-0S6_WzA2Mj")
+	location := authorize(t, params)
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("client_id", "beezy")
+	form.Set("code", location.Query().Get("code"))
+	form.Set("redirect_uri", redirectURI)
+	res := requestToken(t, form, nil)
+
+	if res.IDToken == "" {
+		t.Fatalf("token response is missing id_token")
+	}
+
+	claims := mustParseToken(t, res.IDToken)
+	want := map[string]any{
+		"iss":   "http://example.com",
+		"sub":   "testuser",
+		"aud":   "beezy",
+		"nonce": "n-0S6_WzA2Mj",
+	}
+	for claim, value := range want {
+		if claims[claim] != value {
+			t.Errorf("id_token %s = %v, want %v", claim, claims[claim], value)
+		}
+	}
+	if _, ok := claims["auth_time"].(float64); !ok {
+		t.Errorf("id_token is missing auth_time")
+	}
+}
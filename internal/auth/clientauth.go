@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodClientSecretPost  = "client_secret_post"
+	AuthMethodClientSecretJWT   = "client_secret_jwt"
+	AuthMethodPrivateKeyJWT     = "private_key_jwt"
+	AuthMethodTLSClientAuth     = "tls_client_auth"
+)
+
+var (
+	errMissingClientCredentials = errors.New("missing client credentials")
+	errInvalidClientCredentials = errors.New("invalid client id or secret")
+)
+
+var clientSecretJWTAlgs = []string{"HS256", "HS384", "HS512"}
+
+// ClientSecretFunc looks up the shared secret of a client for verifying
+// client_secret_jwt assertions.
+type ClientSecretFunc func(clientID string) (string, bool)
+
+// RegisteredClientSecret is a ClientSecretFunc backed by the client registry.
+func RegisteredClientSecret(clientID string) (string, bool) {
+	client, ok := LookupClient(clientID)
+	if !ok || client.ClientSecret == "" {
+		return "", false
+	}
+
+	return client.ClientSecret, true
+}
+
+// authenticateClient authenticates the client calling a token endpoint with
+// any of the supported methods (RFC 6749 client secrets, RFC 7523 client
+// assertions or RFC 8705 mutual TLS) and returns the registered client.
+func authenticateClient(r *http.Request, form TokenForm) (Client, error) {
+	var clientID, secret, method string
+
+	switch {
+	case r.PostForm.Get("client_assertion") != "" || r.PostForm.Get("client_assertion_type") != "":
+		var err error
+		clientID, method, err = VerifyClientAssertion(r, RegisteredClientSecret)
+		if err != nil {
+			return Client{}, err
+		}
+	case form.ClientSecret == "" && HasTLSClientCertificate(r) && !hasBasicAuth(r):
+		clientID = form.ClientID
+		method = AuthMethodTLSClientAuth
+		if err := VerifyTLSClientCertificate(r, clientID); err != nil {
+			return Client{}, err
+		}
+	default:
+		clientID, secret = form.ClientID, form.ClientSecret
+		method = AuthMethodClientSecretPost
+		if clientID == "" && secret == "" {
+			clientID, secret, _ = r.BasicAuth()
+			_, clientID = splitTenant(clientID)
+			method = AuthMethodClientSecretBasic
+		}
+		if clientID == "" && secret == "" {
+			return Client{}, errMissingClientCredentials
+		}
+	}
+
+	client, ok := LookupClient(clientID)
+	if !ok {
+		return Client{}, errInvalidClientCredentials
+	}
+	if (method == AuthMethodClientSecretPost || method == AuthMethodClientSecretBasic) && !client.ValidSecret(secret) {
+		return Client{}, errInvalidClientCredentials
+	}
+	if !client.AllowsAuthMethod(method) {
+		return Client{}, fmt.Errorf("client is not registered for %s authentication", method)
+	}
+
+	return client, nil
+}
+
+// authenticatePublicClient identifies the client of the authorization code and
+// device grants. These are usually public clients that cannot authenticate,
+// so credentials are only verified when presented or required by the client's
+// registration.
+func authenticatePublicClient(r *http.Request, form TokenForm) (Client, OAuth2ErrorCode, string) {
+	client, err := authenticateClient(r, form)
+	if err == nil {
+		return client, "", ""
+	}
+	if !errors.Is(err, errInvalidClientCredentials) || form.ClientSecret != "" || hasBasicAuth(r) {
+		return Client{}, ErrCodeInvalidClient, err.Error()
+	}
+
+	client, ok := LookupClient(form.ClientID)
+	if !ok {
+		return Client{}, ErrCodeInvalidClient, "invalid client id"
+	}
+	if client.TokenEndpointAuthMethod != "" && client.TokenEndpointAuthMethod != AuthMethodNone {
+		return Client{}, ErrCodeInvalidClient, "client authentication required"
+	}
+
+	return client, "", ""
+}
+
+func hasBasicAuth(r *http.Request) bool {
+	_, _, ok := r.BasicAuth()
+	return ok
+}
+
+// hasClientCredentials reports whether the request authenticates the client
+// without the client_id form parameter, in an Authorization header or with a
+// client assertion.
+func hasClientCredentials(r *http.Request) bool {
+	return hasBasicAuth(r) || r.PostForm.Get("client_assertion") != ""
+}
+
+// VerifyClientAssertion authenticates a client using the client_assertion and
+// client_assertion_type form parameters. Assertions signed with an HMAC
+// algorithm are verified as client_secret_jwt using secrets, all others as
+// private_key_jwt against the client's registered public keys. The client ID
+// and the authentication method are returned.
+func VerifyClientAssertion(r *http.Request, secrets ClientSecretFunc) (string, string, error) {
+	if r.PostForm.Get("client_assertion_type") != ClientAssertionTypeJWTBearer {
+		return "", "", errors.New("unsupported client_assertion_type")
+	}
+
+	assertion := r.PostForm.Get("client_assertion")
+	if assertion == "" {
+		return "", "", errors.New("missing client_assertion")
+	}
+
+	method := AuthMethodPrivateKeyJWT
+	keyfunc := func(clientID string) jwt.Keyfunc {
+		privateKey := clientAssertionKey(clientID)
+		return func(token *jwt.Token) (any, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return privateKey(token)
+			}
+
+			method = AuthMethodClientSecretJWT
+			secret, ok := secrets(clientID)
+			if !ok {
+				return nil, fmt.Errorf("client %s has no shared secret", clientID)
+			}
+			return []byte(secret), nil
+		}
+	}
+
+	claims, err := parseClientAssertion(r, assertion, keyfunc, append(clientSecretJWTAlgs, assertionSigningAlgs...))
+	if err != nil {
+		return "", "", err
+	}
+
+	// RFC 7523 section 3: for client authentication both iss and sub must be
+	// the client ID.
+	issuer, _ := claims.GetIssuer()
+	subject, _ := claims.GetSubject()
+	if subject != issuer {
+		return "", "", errors.New("client assertion sub must equal iss")
+	}
+	if clientID := requestClientID(r); clientID != "" && clientID != issuer {
+		return "", "", errors.New("client assertion was not issued by client_id")
+	}
+
+	return issuer, method, nil
+}
+
+func HasTLSClientCertificate(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.PeerCertificates) > 0
+}
+
+// VerifyTLSClientCertificate implements the tls_client_auth method of RFC 8705
+// using the PKI method: the client presents a certificate whose subject common
+// name is its client ID. Whether the certificate chains to a trusted CA is
+// decided by the server's TLS configuration.
+func VerifyTLSClientCertificate(r *http.Request, clientID string) error {
+	if !HasTLSClientCertificate(r) {
+		return errors.New("no client certificate presented")
+	}
+	if clientID == "" {
+		return errors.New("client_id is required with tls_client_auth")
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	if !strings.EqualFold(cert.Subject.CommonName, clientID) {
+		return fmt.Errorf("client certificate subject %q does not match client_id", cert.Subject.String())
+	}
+
+	return nil
+}
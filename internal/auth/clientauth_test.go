@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAuthenticateClient(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterClientKeys("beezy", JWKS{Keys: []JWK{publicJWK(&signingKey{
+		Kid:        "beezy-es256",
+		Alg:        SigningAlgES256,
+		PrivateKey: ecKey,
+	})}}); err != nil {
+		t.Fatalf("RegisterClientKeys() error = %v", err)
+	}
+
+	assertion := func(method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = "beezy-es256"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	beezyClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": "beezy",
+			"sub": "beezy",
+			"aud": "http://example.com/oauth2/token",
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+	}
+
+	tests := []struct {
+		name         string
+		setupRequest func() *http.Request
+		wantClientID string
+		wantErr      bool
+	}{
+		{
+			name: "private_key_jwt",
+			setupRequest: func() *http.Request {
+				form := url.Values{}
+				form.Set("client_assertion_type", ClientAssertionTypeJWTBearer)
+				form.Set("client_assertion", assertion(jwt.SigningMethodES256, ecKey, beezyClaims()))
+				return tokenEndpointRequest(form)
+			},
+			wantClientID: "beezy",
+		},
+		{
+			name: "client_secret_jwt",
+			setupRequest: func() *http.Request {
+				form := url.Values{}
+				form.Set("client_assertion_type", ClientAssertionTypeJWTBearer)
+				form.Set("client_assertion", assertion(jwt.SigningMethodHS256, []byte("super-secret"), beezyClaims()))
+				return tokenEndpointRequest(form)
+			},
+			wantClientID: "beezy",
+		},
+		{
+			name: "client_secret_jwt with wrong secret",
+			setupRequest: func() *http.Request {
+				form := url.Values{}
+				form.Set("client_assertion_type", ClientAssertionTypeJWTBearer)
+				form.Set("client_assertion", assertion(jwt.SigningMethodHS256, []byte("wrong"), beezyClaims()))
+				return tokenEndpointRequest(form)
+			},
+			wantErr: true,
+		},
+		{
+			name: "assertion sub differs from iss",
+			setupRequest: func() *http.Request {
+				claims := beezyClaims()
+				claims["sub"] = "someone-else"
+				form := url.Values{}
+				form.Set("client_assertion_type", ClientAssertionTypeJWTBearer)
+				form.Set("client_assertion", assertion(jwt.SigningMethodES256, ecKey, claims))
+				return tokenEndpointRequest(form)
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls_client_auth",
+			setupRequest: func() *http.Request {
+				form := url.Values{}
+				form.Set("client_id", "beezy")
+				req := tokenEndpointRequest(form)
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+					{Subject: pkix.Name{CommonName: "beezy"}},
+				}}
+				return req
+			},
+			wantClientID: "beezy",
+		},
+		{
+			name: "tls_client_auth with mismatched subject",
+			setupRequest: func() *http.Request {
+				form := url.Values{}
+				form.Set("client_id", "beezy")
+				req := tokenEndpointRequest(form)
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+					{Subject: pkix.Name{CommonName: "intruder"}},
+				}}
+				return req
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.setupRequest()
+			if err := req.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+
+			client, err := authenticateClient(req, TokenForm{ClientID: req.PostForm.Get("client_id")})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("authenticateClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if client.ClientID != tt.wantClientID {
+				t.Errorf("authenticateClient() = %q, want %q", client.ClientID, tt.wantClientID)
+			}
+		})
+	}
+}
+
+func tokenEndpointRequest(form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+const clientAssertionLeeway = 30 * time.Second
+
+// clientKeysDB maps client IDs to the public keys they sign assertions with.
+var clientKeysDB sync.Map
+
+// usedAssertionIDs records the jti of every accepted assertion until it
+// expires so that assertions cannot be replayed.
+var usedAssertionIDs sync.Map
+
+type clientKey struct {
+	Kid       string
+	Alg       string
+	PublicKey crypto.PublicKey
+}
+
+func decodeJWKField(name, value string) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("missing %s", name)
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	return b, nil
+}
+
+// PublicKey decodes the public key described by the JWK.
+func (jwk JWK) PublicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeJWKField("n", jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKField("e", jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := decodeJWKField("x", jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKField("y", jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 coordinates")
+		}
+
+		// Round trip through crypto/ecdh to validate the point is on the curve.
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("invalid P-256 point: %w", err)
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := decodeJWKField("x", jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+	}
+}
+
+func keyMatchesMethod(key crypto.PublicKey, method jwt.SigningMethod) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodRSA)
+		if !ok {
+			_, ok = method.(*jwt.SigningMethodRSAPSS)
+		}
+		return ok
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	case ed25519.PublicKey:
+		_, ok := method.(*jwt.SigningMethodEd25519)
+		return ok
+	}
+
+	return false
+}
+
+// RegisterClientKeys replaces the public keys registered for a client.
+func RegisterClientKeys(clientID string, jwks JWKS) error {
+	keys := make([]clientKey, 0, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			return fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		keys = append(keys, clientKey{Kid: jwk.Kid, Alg: jwk.Alg, PublicKey: pub})
+	}
+
+	clientKeysDB.Store(clientID, keys)
+	return nil
+}
+
+// clientAssertionKey returns a jwt.Keyfunc resolving the signing key of an
+// assertion issued by clientID, preferring an exact kid match.
+func clientAssertionKey(clientID string) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		v, found := clientKeysDB.Load(clientID)
+		if !found {
+			return nil, fmt.Errorf("no keys registered for client %s", clientID)
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range v.([]clientKey) {
+			if kid != "" && key.Kid != kid {
+				continue
+			}
+			if key.Alg != "" && key.Alg != token.Method.Alg() {
+				continue
+			}
+			if keyMatchesMethod(key.PublicKey, token.Method) {
+				return key.PublicKey, nil
+			}
+		}
+
+		return nil, fmt.Errorf("no registered key of client %s matches the assertion", clientID)
+	}
+}
+
+// validAssertionAudience reports whether the assertion is addressed to this
+// authorization server, either by issuer or by token endpoint URL.
+func validAssertionAudience(r *http.Request, claims jwt.MapClaims) bool {
+	aud, err := claims.GetAudience()
+	if err != nil {
+		return false
+	}
+
+	issuer := issuerURL(r)
+	for _, a := range aud {
+		if a == issuer || a == issuer+r.URL.Path || a == issuer+"/oauth2/token" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseClientAssertion verifies a JWT signed by a client as described in
+// RFC 7523: it must be issued by the client, addressed to this server, unexpired
+// and not previously used. The verified claims are returned.
+func parseClientAssertion(r *http.Request, assertion string, keyfunc func(clientID string) jwt.Keyfunc, validMethods []string) (jwt.MapClaims, error) {
+	unverified, _, err := jwt.NewParser().ParseUnverified(assertion, jwt.MapClaims{})
+	if err != nil {
+		return nil, fmt.Errorf("malformed assertion: %w", err)
+	}
+	issuer, err := unverified.Claims.GetIssuer()
+	if err != nil || issuer == "" {
+		return nil, errors.New("assertion is missing iss")
+	}
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(validMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clientAssertionLeeway),
+	)
+	token, err := parser.Parse(assertion, keyfunc(issuer))
+	if err != nil {
+		return nil, fmt.Errorf("invalid assertion: %w", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+
+	if !validAssertionAudience(r, claims) {
+		return nil, errors.New("assertion audience does not identify this server")
+	}
+
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		exp, _ := claims.GetExpirationTime()
+		if _, replayed := usedAssertionIDs.LoadOrStore(issuer+":"+jti, exp.Time); replayed {
+			return nil, errors.New("assertion has already been used")
+		}
+	}
+
+	return claims, nil
+}
+
+// HandleRegisterClientKeys registers the JWKS in the request body as the
+// public keys of the client named in the path, for use with the jwt-bearer
+// grant and private_key_jwt client authentication.
+func HandleRegisterClientKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	defer r.Body.Close()
+
+	clientID := mux.Vars(r)["client_id"]
+	client, ok := LookupClient(clientID)
+	if !ok {
+		http.Error(w, `{"error": "client not found"}`, http.StatusNotFound)
+		return
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(r.Body).Decode(&jwks); err != nil {
+		http.Error(w, `{"error": "could not decode JWKS"}`, http.StatusBadRequest)
+		return
+	}
+
+	client.JWKS = &jwks
+	if err := RegisterClient(client); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// Clock supplies the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the clock of requests without a controlled session clock.
+// Tests may replace it.
+var SystemClock Clock = systemClock{}
+
+// TestClock is a clock controlled through the admin API. It runs at the pace
+// of SystemClock shifted by an offset, or stands still while frozen.
+type TestClock struct {
+	mu     sync.Mutex
+	offset time.Duration
+	frozen bool
+	at     time.Time
+}
+
+func (c *TestClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.frozen {
+		return c.at
+	}
+
+	return SystemClock.Now().Add(c.offset)
+}
+
+// Freeze stops the clock at its current time.
+func (c *TestClock) Freeze() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.frozen {
+		c.at = SystemClock.Now().Add(c.offset)
+		c.frozen = true
+	}
+}
+
+// Unfreeze lets the clock run again from the time it was frozen at.
+func (c *TestClock) Unfreeze() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.frozen {
+		c.offset = c.at.Sub(SystemClock.Now())
+		c.frozen = false
+	}
+}
+
+// Advance moves the clock forward by d.
+func (c *TestClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.offset += d
+	c.at = c.at.Add(d)
+}
+
+// Set moves the clock to t.
+func (c *TestClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.offset = t.Sub(SystemClock.Now())
+	c.at = t
+}
+
+var sessionClocks sync.Map
+
+// sessionClock returns the clock of a test session, creating a controllable
+// clock for it when create is set. The clock of the default session applies
+// to requests without a session.
+func sessionClock(session string, create bool) (*TestClock, bool) {
+	if create {
+		v, _ := sessionClocks.LoadOrStore(sessionKey(session), &TestClock{})
+		return v.(*TestClock), true
+	}
+
+	v, ok := sessionClocks.Load(sessionKey(session))
+	if !ok {
+		return nil, false
+	}
+
+	return v.(*TestClock), true
+}
+
+// clockFor returns the clock of a test session, falling back to SystemClock.
+func clockFor(session string) Clock {
+	if clock, ok := sessionClock(session, false); ok {
+		return clock
+	}
+
+	return SystemClock
+}
+
+// SessionClock returns the clock of a test session, as clockFor.
+func SessionClock(session string) Clock {
+	return clockFor(session)
+}
+
+// requestNow returns the current time in the session of r.
+func requestNow(r *http.Request) time.Time {
+	return clockFor(requestSession(r)).Now()
+}
+
+// tokenNow returns the current time in the session a token was issued in.
+func tokenNow(tokenClaims jwt.MapClaims) time.Time {
+	session, _ := tokenClaims["session"].(string)
+	return clockFor(session).Now()
+}
+
+type ClockResponse struct {
+	Session string    `json:"session"`
+	Now     time.Time `json:"now"`
+	Frozen  bool      `json:"frozen"`
+}
+
+func writeClock(w http.ResponseWriter, session string) {
+	res := ClockResponse{
+		Session: session,
+		Now:     clockFor(session).Now(),
+	}
+	if clock, ok := sessionClock(session, false); ok {
+		clock.mu.Lock()
+		res.Frozen = clock.frozen
+		clock.mu.Unlock()
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func HandleGetClock(w http.ResponseWriter, r *http.Request) {
+	writeClock(w, mux.Vars(r)["session"])
+}
+
+// HandleUpdateClock controls the clock of the session in the path. The action
+// is one of freeze, unfreeze, advance (by the seconds query parameter) or set
+// (to the RFC 3339 time query parameter).
+func HandleUpdateClock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	session := vars["session"]
+	clock, _ := sessionClock(session, true)
+
+	switch vars["action"] {
+	case "freeze":
+		clock.Freeze()
+	case "unfreeze":
+		clock.Unfreeze()
+	case "advance":
+		seconds, err := strconv.Atoi(r.URL.Query().Get("seconds"))
+		if err != nil || seconds < 0 {
+			http.Error(w, `{"error": "seconds must be a non-negative integer"}`, http.StatusBadRequest)
+			return
+		}
+		clock.Advance(time.Duration(seconds) * time.Second)
+	case "set":
+		t, err := time.Parse(time.RFC3339, r.URL.Query().Get("time"))
+		if err != nil {
+			http.Error(w, `{"error": "time must be an RFC 3339 timestamp"}`, http.StatusBadRequest)
+			return
+		}
+		clock.Set(t)
+	default:
+		http.Error(w, `{"error": "unknown clock action"}`, http.StatusNotFound)
+		return
+	}
+
+	writeClock(w, session)
+}
+
+// HandleResetClock returns the session to the system clock.
+func HandleResetClock(w http.ResponseWriter, r *http.Request) {
+	if _, loaded := sessionClocks.LoadAndDelete(sessionKey(mux.Vars(r)["session"])); !loaded {
+		http.Error(w, `{"error": "session has no clock"}`, http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func updateClock(t *testing.T, session, action, query string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/oauth2/clock/"+session+"/"+action+"?"+query, nil)
+	req = mux.SetURLVars(req, map[string]string{"session": session, "action": action})
+	w := httptest.NewRecorder()
+	HandleUpdateClock(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandleUpdateClock(%s) status = %v: %s", action, w.Code, w.Body.String())
+	}
+}
+
+func TestSessionClock(t *testing.T) {
+	const session = "clock-test"
+	updateClock(t, session, "freeze", "")
+	updateClock(t, session, "set", "time=2030-01-01T00:00:00Z")
+
+	res := requestToken(t, clientCredentialsForm(), map[string]string{SessionHeader: session})
+	claims := mustParseToken(t, res.AccessToken)
+	if iat := claims["iat"].(float64); iat != float64(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).Unix()) {
+		t.Errorf("iat = %v, want the session time", iat)
+	}
+	if IsTokenExpired(claims) {
+		t.Fatal("token expired before its session clock advanced")
+	}
+
+	updateClock(t, session, "advance", "seconds=3601")
+	if !IsTokenExpired(claims) {
+		t.Error("token not expired after advancing the session clock past its lifetime")
+	}
+
+	other := mustParseToken(t, requestToken(t, clientCredentialsForm(), nil).AccessToken)
+	if IsTokenExpired(other) {
+		t.Error("token outside the session expired")
+	}
+}
+
+func TestDefaultSessionClock(t *testing.T) {
+	defer sessionClocks.Delete(defaultSession)
+	updateClock(t, defaultSession, "freeze", "")
+	updateClock(t, defaultSession, "set", "time=2030-01-01T00:00:00Z")
+
+	claims := mustParseToken(t, requestToken(t, clientCredentialsForm(), nil).AccessToken)
+	if iat := claims["iat"].(float64); iat != float64(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).Unix()) {
+		t.Errorf("iat = %v, want the default session time", iat)
+	}
+}
+
+// manualClock is a SystemClock that only moves when told to.
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+func TestDevicePollingWithFrozenClock(t *testing.T) {
+	const session = "device-frozen"
+	system := &manualClock{now: time.Now()}
+	defer func(clock Clock) { SystemClock = clock }(SystemClock)
+	SystemClock = system
+	defer sessionClocks.Delete(session)
+	updateClock(t, session, "freeze", "")
+
+	form := url.Values{}
+	form.Set("client_id", "beezy")
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/device_authorization", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(SessionHeader, session)
+	w := httptest.NewRecorder()
+	HandleOAuth2DeviceAuthorization(w, req)
+	var device DeviceAuthorizationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &device); err != nil {
+		t.Fatalf("failed to decode device authorization response: %v", err)
+	}
+
+	poll := func() string {
+		form := url.Values{}
+		form.Set("grant_type", GrantTypeDeviceCode)
+		form.Set("client_id", "beezy")
+		form.Set("device_code", device.DeviceCode)
+		return exchangeCode(form).Body.String()
+	}
+
+	if body := poll(); !strings.Contains(body, string(ErrCodeAuthorizationPending)) {
+		t.Fatalf("first poll = %s, want authorization_pending", body)
+	}
+	system.now = system.now.Add(defaultDevicePollInterval)
+	if body := poll(); !strings.Contains(body, string(ErrCodeAuthorizationPending)) {
+		t.Errorf("poll after the interval = %s, want authorization_pending", body)
+	}
+	if body := poll(); !strings.Contains(body, string(ErrCodeSlowDown)) {
+		t.Errorf("immediate poll = %s, want slow_down", body)
+	}
+}
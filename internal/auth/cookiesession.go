@@ -0,0 +1,270 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+)
+
+const (
+	SessionCookieName = "session_id"
+	CSRFCookieName    = "csrf_token"
+	CSRFHeader        = "X-CSRF-Token"
+
+	// defaultCookieSessionLifetime applies when login does not set max_age.
+	defaultCookieSessionLifetime = time.Hour
+)
+
+// CookieSession is a logged in user authenticated by the session cookie.
+type CookieSession struct {
+	ID        string
+	Username  string
+	CSRFToken string
+	ExpiresAt time.Time
+	// Data holds the values stored through the protected session routes.
+	Data map[string]any
+
+	mu      sync.Mutex
+	session string
+	// cookie holds the attributes of the login cookies.
+	cookie http.Cookie
+}
+
+// Update merges values into the session data.
+func (s *CookieSession) Update(values map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range values {
+		s.Data[k] = v
+	}
+}
+
+func (s *CookieSession) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type cookieSession struct {
+		Username  string         `json:"username"`
+		CSRFToken string         `json:"csrfToken"`
+		ExpiresAt time.Time      `json:"expiresAt"`
+		Data      map[string]any `json:"data"`
+	}
+
+	return json.Marshal(cookieSession{
+		Username:  s.Username,
+		CSRFToken: s.CSRFToken,
+		ExpiresAt: s.ExpiresAt,
+		Data:      s.Data,
+	})
+}
+
+var cookieSessions = sync.Map{}
+
+var (
+	ErrNoSessionCookie = errors.New("missing session cookie")
+	ErrUnknownSession  = errors.New("unknown or logged out session")
+	ErrSessionExpired  = errors.New("session has expired")
+	ErrCSRFMismatch    = errors.New("missing or mismatched CSRF token")
+)
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// LookupCookieSession returns the unexpired session of the session cookie of
+// r.
+func LookupCookieSession(r *http.Request) (*CookieSession, error) {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return nil, ErrNoSessionCookie
+	}
+
+	v, ok := cookieSessions.Load(cookie.Value)
+	if !ok {
+		return nil, ErrUnknownSession
+	}
+
+	s := v.(*CookieSession)
+	if !clockFor(s.session).Now().Before(s.ExpiresAt) {
+		cookieSessions.Delete(s.ID)
+		return nil, ErrSessionExpired
+	}
+
+	return s, nil
+}
+
+// VerifyCSRF checks the double submit CSRF token of a state changing request:
+// the CSRF header must match both the CSRF cookie and the session.
+func VerifyCSRF(r *http.Request, s *CookieSession) error {
+	header := r.Header.Get(CSRFHeader)
+	cookie, err := r.Cookie(CSRFCookieName)
+	if header == "" || err != nil {
+		return ErrCSRFMismatch
+	}
+
+	if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(header), []byte(s.CSRFToken)) != 1 {
+		return ErrCSRFMismatch
+	}
+
+	return nil
+}
+
+func defaultCookieAttributes() http.Cookie {
+	return http.Cookie{
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(defaultCookieSessionLifetime.Seconds()),
+	}
+}
+
+// cookieAttributes reads the cookie attributes of a login from the samesite
+// (lax, strict or none), secure, httponly and max_age (seconds) query
+// parameters. Cookies are SameSite=Lax and HttpOnly by default; Secure is off
+// so that they work over plain HTTP.
+func cookieAttributes(r *http.Request) (http.Cookie, error) {
+	query := r.URL.Query()
+	cookie := defaultCookieAttributes()
+
+	switch strings.ToLower(query.Get("samesite")) {
+	case "", "lax":
+	case "strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "none":
+		cookie.SameSite = http.SameSiteNoneMode
+	default:
+		return cookie, errors.New("samesite must be lax, strict or none")
+	}
+
+	for name, attr := range map[string]*bool{"secure": &cookie.Secure, "httponly": &cookie.HttpOnly} {
+		if v := query.Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return cookie, errors.New(name + " must be a boolean")
+			}
+			*attr = b
+		}
+	}
+
+	if v := query.Get("max_age"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return cookie, errors.New("max_age must be a positive integer")
+		}
+		cookie.MaxAge = seconds
+	}
+
+	if cookie.SameSite == http.SameSiteNoneMode && !cookie.Secure {
+		return cookie, errors.New("samesite=none requires secure=true")
+	}
+
+	return cookie, nil
+}
+
+// HandleSessionLogin authenticates a registered user with the username and
+// password of a url-encoded or JSON body and sets the session cookie and the
+// CSRF cookie. The CSRF cookie is never HttpOnly as clients echo it in the
+// X-CSRF-Token header of state changing requests.
+func HandleSessionLogin(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if err := ParseTokenRequest(r); err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "cannot parse request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	attrs, err := cookieAttributes(r)
+	if err != nil {
+		http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+
+	username := r.PostForm.Get("username")
+	if !ValidUserCredentials(username, r.PostForm.Get("password")) {
+		http.Error(w, `{"error": "invalid username or password"}`, http.StatusUnauthorized)
+		return
+	}
+
+	session := requestSession(r)
+	s := &CookieSession{
+		ID:        gofakeit.UUID(),
+		Username:  username,
+		CSRFToken: randomToken(),
+		ExpiresAt: clockFor(session).Now().Add(time.Duration(attrs.MaxAge) * time.Second),
+		Data:      map[string]any{},
+		session:   session,
+		cookie:    attrs,
+	}
+	cookieSessions.Store(s.ID, s)
+
+	attrs.Expires = s.ExpiresAt
+	setSessionCookies(w, attrs, s.ID, s.CSRFToken)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, s)
+}
+
+// setSessionCookies sets the session cookie and the CSRF cookie with the
+// given attributes.
+func setSessionCookies(w http.ResponseWriter, attrs http.Cookie, sessionID, csrfToken string) {
+	sessionCookie := attrs
+	sessionCookie.Name = SessionCookieName
+	sessionCookie.Value = sessionID
+	http.SetCookie(w, &sessionCookie)
+
+	csrfCookie := attrs
+	csrfCookie.Name = CSRFCookieName
+	csrfCookie.Value = csrfToken
+	csrfCookie.HttpOnly = false
+	http.SetCookie(w, &csrfCookie)
+}
+
+// HandleSessionLogout ends the session of the session cookie, if any, and
+// clears both cookies with the attributes they were set with. Ending a live
+// session is state changing, so it must pass the CSRF check.
+func HandleSessionLogout(w http.ResponseWriter, r *http.Request) {
+	attrs := defaultCookieAttributes()
+	if s, err := LookupCookieSession(r); err == nil {
+		if err := VerifyCSRF(r, s); err != nil {
+			http.Error(w, `{"error": "`+err.Error()+`"}`, http.StatusForbidden)
+			return
+		}
+		cookieSessions.Delete(s.ID)
+		attrs = s.cookie
+	}
+
+	attrs.MaxAge = -1
+	attrs.Expires = time.Unix(0, 0)
+	setSessionCookies(w, attrs, "", "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeCookieSessions drops expired cookie sessions.
+func purgeCookieSessions() {
+	cookieSessions.Range(func(key, value any) bool {
+		s := value.(*CookieSession)
+		if !clockFor(s.session).Now().Before(s.ExpiresAt) {
+			cookieSessions.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func login(t *testing.T, query string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	form := url.Values{"username": {"testuser"}, "password": {"testpassword"}}
+	req := httptest.NewRequest(http.MethodPost, "/session/login?"+query, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	HandleSessionLogin(w, req)
+
+	return w
+}
+
+func TestCookieSession(t *testing.T) {
+	const session = "cookie-session-test"
+	updateClock(t, session, "freeze", "")
+
+	w := login(t, "samesite=strict&secure=true&max_age=60", map[string]string{SessionHeader: session})
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandleSessionLogin() status = %v: %s", w.Code, w.Body.String())
+	}
+
+	cookies := map[string]*http.Cookie{}
+	for _, c := range w.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+	sessionCookie, csrfCookie := cookies[SessionCookieName], cookies[CSRFCookieName]
+	if sessionCookie == nil || csrfCookie == nil {
+		t.Fatalf("missing cookies: %v", w.Result().Cookies())
+	}
+	if !sessionCookie.HttpOnly || !sessionCookie.Secure || sessionCookie.SameSite != http.SameSiteStrictMode || sessionCookie.MaxAge != 60 {
+		t.Errorf("unexpected session cookie attributes %+v", sessionCookie)
+	}
+	if csrfCookie.HttpOnly {
+		t.Error("CSRF cookie must be readable by clients")
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/session/me", nil)
+	req.AddCookie(sessionCookie)
+	req.AddCookie(csrfCookie)
+	s, err := LookupCookieSession(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Username != "testuser" {
+		t.Errorf("Username = %q", s.Username)
+	}
+
+	if err := VerifyCSRF(req, s); !errors.Is(err, ErrCSRFMismatch) {
+		t.Errorf("VerifyCSRF() without header = %v", err)
+	}
+	req.Header.Set(CSRFHeader, "forged")
+	if err := VerifyCSRF(req, s); !errors.Is(err, ErrCSRFMismatch) {
+		t.Errorf("VerifyCSRF() with forged header = %v", err)
+	}
+	req.Header.Set(CSRFHeader, csrfCookie.Value)
+	if err := VerifyCSRF(req, s); err != nil {
+		t.Errorf("VerifyCSRF() = %v", err)
+	}
+
+	updateClock(t, session, "advance", "seconds=60")
+	if _, err := LookupCookieSession(req); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("LookupCookieSession() after expiry = %v", err)
+	}
+}
+
+func TestCookieSessionLogout(t *testing.T) {
+	w := login(t, "samesite=strict&secure=true", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandleSessionLogin() status = %v: %s", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/session/logout", nil)
+	var csrfToken string
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+		if c.Name == CSRFCookieName {
+			csrfToken = c.Value
+		}
+	}
+
+	logout := httptest.NewRecorder()
+	HandleSessionLogout(logout, req)
+	if logout.Code != http.StatusForbidden {
+		t.Errorf("HandleSessionLogout() without CSRF header status = %v, want %v", logout.Code, http.StatusForbidden)
+	}
+	if _, err := LookupCookieSession(req); err != nil {
+		t.Fatalf("LookupCookieSession() after rejected logout = %v", err)
+	}
+
+	req.Header.Set(CSRFHeader, csrfToken)
+	logout = httptest.NewRecorder()
+	HandleSessionLogout(logout, req)
+	if logout.Code != http.StatusNoContent {
+		t.Errorf("HandleSessionLogout() status = %v, want %v", logout.Code, http.StatusNoContent)
+	}
+	if _, err := LookupCookieSession(req); !errors.Is(err, ErrUnknownSession) {
+		t.Errorf("LookupCookieSession() after logout = %v", err)
+	}
+
+	cleared := logout.Result().Cookies()
+	if len(cleared) != 2 {
+		t.Fatalf("cleared cookies = %v, want both cookies", cleared)
+	}
+	for _, c := range cleared {
+		if c.MaxAge >= 0 || !c.Secure || c.SameSite != http.SameSiteStrictMode {
+			t.Errorf("cleared cookie %s = %+v, want the login attributes", c.Name, c)
+		}
+	}
+}
+
+func TestCookieSessionLoginErrors(t *testing.T) {
+	if w := login(t, "samesite=none", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("samesite=none without secure status = %v, want %v", w.Code, http.StatusBadRequest)
+	}
+
+	form := url.Values{"username": {"testuser"}, "password": {"wrong"}}
+	req := httptest.NewRequest(http.MethodPost, "/session/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	HandleSessionLogin(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+}
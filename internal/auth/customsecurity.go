@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/speakeasy-api/speakeasy-api-test-service/pkg/models"
+)
+
+// maxCustomSecurityDepth bounds the nesting of AllOf/AnyOf references, which
+// also stops reference cycles.
+const maxCustomSecurityDepth = 8
+
+type customSecurityScheme struct {
+	models.CustomSecurityScheme
+	patterns []*regexp.Regexp
+}
+
+var customSecuritySchemes sync.Map
+
+func init() {
+	if err := RegisterCustomSecurityScheme(models.CustomSecurityScheme{
+		Name: "customSchemeAppId",
+		Fields: []models.CustomSecurityField{
+			{In: "header", Name: "X-Security-App-Id", Value: "testAppID"},
+			{In: "header", Name: "X-Security-Secret", Value: "testSecret"},
+		},
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterCustomSecurityScheme adds or replaces a custom security scheme.
+func RegisterCustomSecurityScheme(scheme models.CustomSecurityScheme) error {
+	if scheme.Name == "" {
+		return errors.New("missing scheme name")
+	}
+	if len(scheme.Fields) == 0 && len(scheme.AllOf) == 0 && len(scheme.AnyOf) == 0 {
+		return errors.New("scheme must have fields, allOf or anyOf")
+	}
+
+	compiled := customSecurityScheme{
+		CustomSecurityScheme: scheme,
+		patterns:             make([]*regexp.Regexp, len(scheme.Fields)),
+	}
+	for i, field := range scheme.Fields {
+		switch field.In {
+		case "header", "query", "cookie":
+		default:
+			return fmt.Errorf("field %s: in must be header, query or cookie", field.Name)
+		}
+		if field.Name == "" {
+			return errors.New("missing field name")
+		}
+		if field.Pattern != "" {
+			pattern, err := regexp.Compile("^(?:" + field.Pattern + ")$")
+			if err != nil {
+				return fmt.Errorf("field %s: %w", field.Name, err)
+			}
+			compiled.patterns[i] = pattern
+		}
+	}
+
+	customSecuritySchemes.Store(scheme.Name, &compiled)
+	return nil
+}
+
+// LoadCustomSecuritySchemes registers the schemes in the JSON array at path.
+func LoadCustomSecuritySchemes(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var schemes []models.CustomSecurityScheme
+	if err := json.Unmarshal(data, &schemes); err != nil {
+		return fmt.Errorf("invalid custom security config %s: %w", path, err)
+	}
+
+	for _, scheme := range schemes {
+		if err := RegisterCustomSecurityScheme(scheme); err != nil {
+			return fmt.Errorf("scheme %q: %w", scheme.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func fieldValue(field models.CustomSecurityField, r *http.Request) (string, bool) {
+	switch field.In {
+	case "query":
+		values, ok := r.URL.Query()[field.Name]
+		if !ok || len(values) == 0 {
+			return "", false
+		}
+		return values[0], true
+	case "cookie":
+		cookie, err := r.Cookie(field.Name)
+		if err != nil {
+			return "", false
+		}
+		return cookie.Value, true
+	default:
+		values := r.Header.Values(field.Name)
+		if len(values) == 0 {
+			return "", false
+		}
+		return values[0], true
+	}
+}
+
+func checkCustomSecurity(name string, r *http.Request, depth int) error {
+	if depth > maxCustomSecurityDepth {
+		return fmt.Errorf("custom security scheme %s nests too deeply", name)
+	}
+
+	v, ok := customSecuritySchemes.Load(name)
+	if !ok {
+		return fmt.Errorf("unknown custom security scheme %s", name)
+	}
+	scheme := v.(*customSecurityScheme)
+
+	for i, field := range scheme.Fields {
+		value, ok := fieldValue(field, r)
+		if !ok {
+			return fmt.Errorf("missing %s %s: %w", field.Name, field.In, authError)
+		}
+
+		if pattern := scheme.patterns[i]; pattern != nil {
+			if !pattern.MatchString(value) {
+				return fmt.Errorf("invalid %s %s: %w", field.Name, field.In, authError)
+			}
+		} else if value != field.Value {
+			return fmt.Errorf("invalid %s %s: %w", field.Name, field.In, authError)
+		}
+	}
+
+	for _, required := range scheme.AllOf {
+		if err := checkCustomSecurity(required, r, depth+1); err != nil {
+			return err
+		}
+	}
+
+	if len(scheme.AnyOf) == 0 {
+		return nil
+	}
+	for _, alternative := range scheme.AnyOf {
+		err := checkCustomSecurity(alternative, r, depth+1)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, authError) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("none of %s satisfied for %s: %w", strings.Join(scheme.AnyOf, ", "), name, authError)
+}
+
+func HandleListCustomSecuritySchemes(w http.ResponseWriter, r *http.Request) {
+	schemes := []models.CustomSecurityScheme{}
+	customSecuritySchemes.Range(func(_, value any) bool {
+		schemes = append(schemes, value.(*customSecurityScheme).CustomSecurityScheme)
+		return true
+	})
+	sort.Slice(schemes, func(i, j int) bool { return schemes[i].Name < schemes[j].Name })
+
+	writeJSON(w, http.StatusOK, schemes)
+}
+
+// HandlePutCustomSecurityScheme registers the scheme named in the path.
+func HandlePutCustomSecurityScheme(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var scheme models.CustomSecurityScheme
+	if err := json.NewDecoder(r.Body).Decode(&scheme); err != nil {
+		http.Error(w, `{"error": "could not decode scheme"}`, http.StatusBadRequest)
+		return
+	}
+	scheme.Name = mux.Vars(r)["customSchemeType"]
+
+	if err := RegisterCustomSecurityScheme(scheme); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, scheme)
+}
+
+func HandleDeleteCustomSecurityScheme(w http.ResponseWriter, r *http.Request) {
+	if _, loaded := customSecuritySchemes.LoadAndDelete(mux.Vars(r)["customSchemeType"]); !loaded {
+		http.Error(w, `{"error": "scheme not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/speakeasy-api/speakeasy-api-test-service/pkg/models"
+)
+
+func TestHandleCustomAuth(t *testing.T) {
+	for _, scheme := range []models.CustomSecurityScheme{
+		{Name: "apiKeyQuery", Fields: []models.CustomSecurityField{{In: "query", Name: "key", Pattern: "k-[0-9]+"}}},
+		{Name: "sessionCookie", Fields: []models.CustomSecurityField{{In: "cookie", Name: "sid", Value: "abc"}}},
+		{Name: "either", AnyOf: []string{"apiKeyQuery", "sessionCookie"}},
+		{Name: "appAndEither", AllOf: []string{"customSchemeAppId", "either"}},
+	} {
+		if err := RegisterCustomSecurityScheme(scheme); err != nil {
+			t.Fatalf("RegisterCustomSecurityScheme(%s) error = %v", scheme.Name, err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		scheme     string
+		setup      func(r *http.Request)
+		wantStatus int
+	}{
+		{
+			name:   "built in app id scheme",
+			scheme: "customSchemeAppId",
+			setup: func(r *http.Request) {
+				r.Header.Set("X-Security-App-Id", "testAppID")
+				r.Header.Set("X-Security-Secret", "testSecret")
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "regex query value",
+			scheme:     "either",
+			setup:      func(r *http.Request) { r.URL.RawQuery = "key=k-42" },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "regex must match the whole value",
+			scheme:     "apiKeyQuery",
+			setup:      func(r *http.Request) { r.URL.RawQuery = "key=k-42x" },
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:   "all of with any of",
+			scheme: "appAndEither",
+			setup: func(r *http.Request) {
+				r.Header.Set("X-Security-App-Id", "testAppID")
+				r.Header.Set("X-Security-Secret", "testSecret")
+				r.AddCookie(&http.Cookie{Name: "sid", Value: "abc"})
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "all of missing a scheme",
+			scheme:     "appAndEither",
+			setup:      func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "sid", Value: "abc"}) },
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth/customsecurity/"+tt.scheme, nil)
+			req = mux.SetURLVars(req, map[string]string{"customSchemeType": tt.scheme})
+			tt.setup(req)
+			w := httptest.NewRecorder()
+			HandleCustomAuth(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("HandleCustomAuth() status = %v, want %v: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
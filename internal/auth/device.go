@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+)
+
+const (
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	ErrCodeAuthorizationPending OAuth2ErrorCode = "authorization_pending"
+	ErrCodeSlowDown             OAuth2ErrorCode = "slow_down"
+	ErrCodeExpiredToken         OAuth2ErrorCode = "expired_token"
+	ErrCodeAccessDenied         OAuth2ErrorCode = "access_denied"
+
+	defaultDeviceCodeLifetime = 10 * time.Minute
+	defaultDevicePollInterval = 5 * time.Second
+	slowDownIncrement         = 5 * time.Second
+
+	// userCodeAlphabet omits vowels and easily confused characters as
+	// recommended by RFC 8628 section 6.1.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+type deviceAuthorizationStatus int
+
+const (
+	deviceAuthorizationPending deviceAuthorizationStatus = iota
+	deviceAuthorizationApproved
+	deviceAuthorizationDenied
+)
+
+type deviceAuthorization struct {
+	ClientID  string
+	Scope     string
+	UserCode  string
+	ExpiresAt time.Time
+
+	mu         sync.Mutex
+	status     deviceAuthorizationStatus
+	interval   time.Duration
+	lastPolled time.Time
+	authTime   time.Time
+	username   string
+	session    string
+}
+
+var (
+	deviceCodeDB sync.Map
+	// userCodeDB maps user codes to their device code.
+	userCodeDB sync.Map
+)
+
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+func generateUserCode() string {
+	var b strings.Builder
+	for i := 0; i < 8; i++ {
+		if i == 4 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			panic(err)
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+
+	return b.String()
+}
+
+func normalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(strings.ReplaceAll(userCode, "-", ""))
+	if len(userCode) != 8 {
+		return userCode
+	}
+
+	return userCode[:4] + "-" + userCode[4:]
+}
+
+// HandleOAuth2DeviceAuthorization implements the RFC 8628 device authorization
+// endpoint. The polling interval and code lifetime can be shortened with the
+// x-oauth2-device-interval and x-oauth2-device-expires-in headers (in seconds)
+// so that tests do not have to wait for the defaults.
+func HandleOAuth2DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	defer r.Body.Close()
+
+	if err := r.ParseForm(); err != nil {
+		log.Println(err)
+		SendOAuth2Error(w, r, ErrCodeInvalidRequest, "cannot parse url-encoded request body")
+		return
+	}
+
+	clientID := requestClientID(r)
+	if clientID == "" {
+		clientID, _, _ = r.BasicAuth()
+		_, clientID = splitTenant(clientID)
+	}
+	if clientID == "" {
+		SendOAuth2Error(w, r, ErrCodeInvalidRequest, "missing client_id")
+		return
+	}
+	client, ok := LookupClient(clientID)
+	if !ok {
+		SendOAuth2Error(w, r, ErrCodeInvalidClient, "invalid client id")
+		return
+	}
+	if !client.AllowsGrant(GrantTypeDeviceCode) {
+		SendOAuth2Error(w, r, ErrCodeUnauthorizedClient, "client is not allowed to use the device code grant")
+		return
+	}
+
+	scope, errCode, description := client.GrantScope(r.PostForm.Get("scope"))
+	if errCode != "" {
+		SendOAuth2Error(w, r, errCode, description)
+		return
+	}
+
+	interval := defaultDevicePollInterval
+	if seconds, err := strconv.Atoi(r.Header.Get("x-oauth2-device-interval")); err == nil && seconds >= 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+	lifetime := defaultDeviceCodeLifetime
+	if seconds, err := strconv.Atoi(r.Header.Get("x-oauth2-device-expires-in")); err == nil && seconds >= 0 {
+		lifetime = time.Duration(seconds) * time.Second
+	}
+
+	deviceCode := gofakeit.UUID()
+	userCode := generateUserCode()
+
+	deviceCodeDB.Store(deviceCode, &deviceAuthorization{
+		ClientID:  clientID,
+		Scope:     scope,
+		UserCode:  userCode,
+		ExpiresAt: requestNow(r).Add(lifetime),
+		interval:  interval,
+		session:   requestSession(r),
+	})
+	userCodeDB.Store(userCode, deviceCode)
+
+	verificationURI := issuerURL(r) + "/oauth2/device"
+	res := DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + userCode,
+		ExpiresIn:               int(lifetime.Seconds()),
+		Interval:                int(interval.Seconds()),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := enc.Encode(res); err != nil {
+		http.Error(w, `{"error": "failed to encode response"}`, http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleOAuth2DeviceVerification stands in for the user visiting the
+// verification URI. Test harnesses post the user_code with an action of
+// "approve" (the default) or "deny", and optionally the username of the
+// approving user.
+func HandleOAuth2DeviceVerification(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		log.Println(err)
+		SendOAuth2Error(w, r, ErrCodeInvalidRequest, "cannot parse request parameters")
+		return
+	}
+
+	userCode := normalizeUserCode(r.Form.Get("user_code"))
+	deviceCode, found := userCodeDB.Load(userCode)
+	if !found {
+		SendOAuth2Error(w, r, ErrCodeInvalidGrant, "unknown user code")
+		return
+	}
+
+	v, found := deviceCodeDB.Load(deviceCode)
+	if !found {
+		SendOAuth2Error(w, r, ErrCodeInvalidGrant, "unknown user code")
+		return
+	}
+	device := v.(*deviceAuthorization)
+
+	device.mu.Lock()
+	defer device.mu.Unlock()
+
+	if device.ExpiresAt.Before(clockFor(device.session).Now()) {
+		SendOAuth2Error(w, r, ErrCodeExpiredToken, "user code has expired")
+		return
+	}
+	if device.status != deviceAuthorizationPending {
+		SendOAuth2Error(w, r, ErrCodeInvalidGrant, "user code has already been used")
+		return
+	}
+
+	username := r.Form.Get("username")
+	if username == "" {
+		username = defaultUsername
+	}
+	if _, ok := LookupUser(username); !ok {
+		SendOAuth2Error(w, r, ErrCodeInvalidRequest, "unknown user "+username)
+		return
+	}
+
+	switch action := r.Form.Get("action"); action {
+	case "", "approve":
+		device.status = deviceAuthorizationApproved
+		device.authTime = clockFor(device.session).Now()
+		device.username = username
+	case "deny":
+		device.status = deviceAuthorizationDenied
+	default:
+		SendOAuth2Error(w, r, ErrCodeInvalidRequest, "action must be approve or deny")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// redeemDeviceCode is called for every token endpoint poll of the
+// authenticated client. It returns the approved authorization once, after
+// which the device code is discarded.
+func redeemDeviceCode(form TokenForm, clientID string) (*deviceAuthorization, OAuth2ErrorCode, string) {
+	v, found := deviceCodeDB.Load(form.DeviceCode)
+	if !found {
+		return nil, ErrCodeInvalidGrant, "invalid device code"
+	}
+	device := v.(*deviceAuthorization)
+
+	device.mu.Lock()
+	defer device.mu.Unlock()
+
+	if device.ClientID != clientID {
+		return nil, ErrCodeInvalidGrant, "device code was issued to another client"
+	}
+
+	now := clockFor(device.session).Now()
+	if device.ExpiresAt.Before(now) {
+		deviceCodeDB.Delete(form.DeviceCode)
+		userCodeDB.Delete(device.UserCode)
+		return nil, ErrCodeExpiredToken, "device code has expired"
+	}
+
+	// The polling interval is measured on the system clock, as a client
+	// waiting between polls does not move a frozen session clock.
+	polledAt := SystemClock.Now()
+	lastPolled := device.lastPolled
+	device.lastPolled = polledAt
+	if !lastPolled.IsZero() && polledAt.Sub(lastPolled) < device.interval {
+		device.interval += slowDownIncrement
+		return nil, ErrCodeSlowDown, "polling too frequently, interval is now " + strconv.Itoa(int(device.interval.Seconds())) + "s"
+	}
+
+	switch device.status {
+	case deviceAuthorizationPending:
+		return nil, ErrCodeAuthorizationPending, "the user has not yet approved the request"
+	case deviceAuthorizationDenied:
+		deviceCodeDB.Delete(form.DeviceCode)
+		userCodeDB.Delete(device.UserCode)
+		return nil, ErrCodeAccessDenied, "the user denied the request"
+	}
+
+	deviceCodeDB.Delete(form.DeviceCode)
+	userCodeDB.Delete(device.UserCode)
+
+	return device, "", ""
+}
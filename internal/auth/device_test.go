@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+	form := url.Values{}
+	form.Set("client_id", "beezy")
+	form.Set("scope", "openid")
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/device_authorization", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	HandleOAuth2DeviceAuthorization(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandleOAuth2DeviceAuthorization() status = %v: %s", w.Code, w.Body.String())
+	}
+
+	var device DeviceAuthorizationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &device); err != nil {
+		t.Fatalf("failed to decode device authorization response: %v", err)
+	}
+	if device.Interval != int(defaultDevicePollInterval.Seconds()) {
+		t.Errorf("interval = %d, want %d", device.Interval, int(defaultDevicePollInterval.Seconds()))
+	}
+
+	poll := func(wantError OAuth2ErrorCode) *httptest.ResponseRecorder {
+		t.Helper()
+
+		form := url.Values{}
+		form.Set("grant_type", GrantTypeDeviceCode)
+		form.Set("client_id", "beezy")
+		form.Set("device_code", device.DeviceCode)
+		w := exchangeCode(form)
+
+		if wantError == "" {
+			if w.Code != http.StatusOK {
+				t.Fatalf("poll status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+		} else if !strings.Contains(w.Body.String(), string(wantError)) {
+			t.Fatalf("poll body = %s, want error %s", w.Body.String(), wantError)
+		}
+		return w
+	}
+
+	// Lets the next poll through without waiting for the interval.
+	skipInterval := func() {
+		v, _ := deviceCodeDB.Load(device.DeviceCode)
+		d := v.(*deviceAuthorization)
+		d.mu.Lock()
+		d.lastPolled = time.Now().Add(-time.Hour)
+		d.mu.Unlock()
+	}
+
+	poll(ErrCodeAuthorizationPending)
+	poll(ErrCodeSlowDown)
+	skipInterval()
+	poll(ErrCodeAuthorizationPending)
+
+	verify := url.Values{}
+	verify.Set("user_code", strings.ToLower(strings.ReplaceAll(device.UserCode, "-", "")))
+	req = httptest.NewRequest(http.MethodPost, "/oauth2/device", strings.NewReader(verify.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	HandleOAuth2DeviceVerification(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("HandleOAuth2DeviceVerification() status = %v: %s", w.Code, w.Body.String())
+	}
+
+	skipInterval()
+	w = poll("")
+
+	var res OAuth2TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+	if res.IDToken == "" {
+		t.Errorf("token response is missing id_token for openid scope")
+	}
+
+	poll(ErrCodeInvalidGrant)
+}
+
+func TestDeviceAuthorizationBasicAuth(t *testing.T) {
+	post := func(path string, form url.Values, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("beezy", "super-secret")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := post("/oauth2/device_authorization", url.Values{}, HandleOAuth2DeviceAuthorization)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandleOAuth2DeviceAuthorization() status = %v: %s", w.Code, w.Body.String())
+	}
+
+	var device DeviceAuthorizationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &device); err != nil {
+		t.Fatalf("failed to decode device authorization response: %v", err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", GrantTypeDeviceCode)
+	form.Set("device_code", device.DeviceCode)
+	w = post("/oauth2/token", form, HandleOAuth2)
+	if !strings.Contains(w.Body.String(), string(ErrCodeAuthorizationPending)) {
+		t.Errorf("poll body = %s, want error %s", w.Body.String(), ErrCodeAuthorizationPending)
+	}
+}
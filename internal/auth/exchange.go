@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	GrantTypeJWTBearer     = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+	TokenTypeAccessToken  = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeRefreshToken = "urn:ietf:params:oauth:token-type:refresh_token"
+	TokenTypeIDToken      = "urn:ietf:params:oauth:token-type:id_token"
+	TokenTypeJWT          = "urn:ietf:params:oauth:token-type:jwt"
+
+	ErrCodeInvalidTarget OAuth2ErrorCode = "invalid_target"
+)
+
+// tokenSubject returns the identity a token was issued for: the resource owner
+// when there is one, otherwise the client.
+func tokenSubject(claims jwt.MapClaims) string {
+	if username, ok := claims["username"].(string); ok && username != "" {
+		return username
+	}
+	if clientID, ok := claims["clientID"].(string); ok && clientID != "" {
+		return clientID
+	}
+	sub, _ := claims.GetSubject()
+	return sub
+}
+
+// validateExchangedToken checks a subject or actor token presented for token
+// exchange. Tokens tracked in tokenDB are checked for revocation and
+// server-side expiry; other JWTs issued by this server (such as ID tokens)
+// only need an unexpired exp claim.
+func validateExchangedToken(token, tokenType string) (jwt.MapClaims, error) {
+	switch tokenType {
+	case TokenTypeAccessToken, TokenTypeRefreshToken, TokenTypeIDToken, TokenTypeJWT:
+	case "":
+		return nil, errors.New("missing token type")
+	default:
+		return nil, errors.New("unsupported token type " + tokenType)
+	}
+
+	claims, err := ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return nil, errors.New("token has no expiry")
+	}
+
+	if _, tracked := TokenID(claims); tracked {
+		if IsTokenRevoked(claims) {
+			return nil, errors.New("token has been revoked")
+		}
+		if IsTokenExpired(claims) {
+			return nil, errors.New("token has expired")
+		}
+	} else if exp.Before(tokenNow(claims)) {
+		return nil, errors.New("token has expired")
+	}
+
+	isRefresh := claims["grantType"] == "refresh_token"
+	switch {
+	case tokenType == TokenTypeRefreshToken && !isRefresh:
+		return nil, errors.New("token is not a refresh token")
+	case tokenType == TokenTypeAccessToken && isRefresh:
+		return nil, errors.New("token is not an access token")
+	}
+
+	return claims, nil
+}
+
+var assertionSigningAlgs = []string{"RS256", "RS384", "RS512", "PS256", "ES256", "EdDSA"}
+
+// grantedIdentity is who a token issued by an assertion-style grant acts for.
+type grantedIdentity struct {
+	ClientID string
+	Username string
+	Scope    string
+	// Claims are added to the issued access token.
+	Claims jwt.MapClaims
+}
+
+// exchangeTokens implements the validation half of RFC 8693. The issued token
+// carries the subject token's identity, an optional audience and, when an
+// actor token is presented, an act claim describing the delegation chain.
+func exchangeTokens(form TokenForm) (*grantedIdentity, OAuth2ErrorCode, string) {
+	if form.SubjectToken == "" {
+		return nil, ErrCodeInvalidRequest, "missing subject_token"
+	}
+	subject, err := validateExchangedToken(form.SubjectToken, form.SubjectTokenType)
+	if err != nil {
+		return nil, ErrCodeInvalidRequest, "invalid subject_token: " + err.Error()
+	}
+
+	switch form.RequestedTokenType {
+	case "", TokenTypeAccessToken, TokenTypeJWT:
+	default:
+		return nil, ErrCodeInvalidRequest, "unsupported requested_token_type"
+	}
+
+	subjectScope, _ := subject["scope"].(string)
+	scope, ok := downscope(subjectScope, form.Scope)
+	if !ok {
+		return nil, ErrCodeInvalidScope, "requested scope exceeds the subject token's scope"
+	}
+
+	claims := jwt.MapClaims{}
+	if len(form.Audience) > 0 {
+		for _, aud := range form.Audience {
+			if strings.TrimSpace(aud) == "" {
+				return nil, ErrCodeInvalidTarget, "invalid audience"
+			}
+		}
+		claims["aud"] = form.Audience
+	}
+
+	if form.ActorToken != "" {
+		actor, err := validateExchangedToken(form.ActorToken, form.ActorTokenType)
+		if err != nil {
+			return nil, ErrCodeInvalidRequest, "invalid actor_token: " + err.Error()
+		}
+
+		act := map[string]any{"sub": tokenSubject(actor)}
+		if prior, ok := subject["act"]; ok {
+			act["act"] = prior
+		}
+		claims["act"] = act
+	} else if form.ActorTokenType != "" {
+		return nil, ErrCodeInvalidRequest, "actor_token_type provided without actor_token"
+	}
+
+	return &grantedIdentity{
+		ClientID: form.ClientID,
+		Username: tokenSubject(subject),
+		Scope:    scope,
+		Claims:   claims,
+	}, "", ""
+}
+
+// redeemJWTBearerAssertion implements the RFC 7523 authorization grant. The
+// assertion is signed by the client (its iss) with a key registered through
+// the admin API and names the user the token is requested for as sub.
+func redeemJWTBearerAssertion(r *http.Request, form TokenForm) (*grantedIdentity, OAuth2ErrorCode, string) {
+	if form.Assertion == "" {
+		return nil, ErrCodeInvalidRequest, "missing assertion"
+	}
+
+	claims, err := parseClientAssertion(r, form.Assertion, clientAssertionKey, assertionSigningAlgs)
+	if err != nil {
+		return nil, ErrCodeInvalidGrant, err.Error()
+	}
+
+	issuer, _ := claims.GetIssuer()
+	if form.ClientID != "" && form.ClientID != issuer {
+		return nil, ErrCodeInvalidGrant, "assertion was not issued by the authenticated client"
+	}
+
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return nil, ErrCodeInvalidGrant, "assertion is missing sub"
+	}
+
+	return &grantedIdentity{
+		ClientID: issuer,
+		Username: sub,
+		Scope:    form.Scope,
+	}, "", ""
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestTokenExchange(t *testing.T) {
+	password := url.Values{}
+	password.Set("grant_type", "password")
+	password.Set("client_id", "beezy")
+	password.Set("client_secret", "super-secret")
+	password.Set("username", "testuser")
+	password.Set("password", "testpassword")
+	password.Set("scope", "read write")
+	subject := requestToken(t, password, nil)
+	actor := requestToken(t, clientCredentialsForm(), nil)
+
+	form := clientCredentialsForm()
+	form.Set("grant_type", GrantTypeTokenExchange)
+	form.Set("subject_token", subject.AccessToken)
+	form.Set("subject_token_type", TokenTypeAccessToken)
+	form.Set("actor_token", actor.AccessToken)
+	form.Set("actor_token_type", TokenTypeAccessToken)
+	form.Set("audience", "https://backend.example.com")
+	form.Set("scope", "read")
+	res := requestToken(t, form, nil)
+
+	if res.IssuedTokenType != TokenTypeAccessToken {
+		t.Errorf("issued_token_type = %q, want %q", res.IssuedTokenType, TokenTypeAccessToken)
+	}
+	if res.RefreshToken != "" {
+		t.Errorf("token exchange issued a refresh token")
+	}
+
+	claims := mustParseToken(t, res.AccessToken)
+	if claims["username"] != "testuser" || claims["scope"] != "read" {
+		t.Errorf("exchanged token claims = %v, want testuser with read scope", claims)
+	}
+	if act, _ := claims["act"].(map[string]any); act["sub"] != "beezy" {
+		t.Errorf("exchanged token act = %v, want sub beezy", claims["act"])
+	}
+
+	form.Set("scope", "admin")
+	w := exchangeCode(form)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), string(ErrCodeInvalidScope)) {
+		t.Errorf("scope escalation: status = %v, body = %s", w.Code, w.Body.String())
+	}
+}
+
+func TestJWTBearerGrant(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterClient(Client{
+		ClientID: "assertion-client",
+		JWKS: &JWKS{Keys: []JWK{{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			Kid: "k1",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}}},
+	}); err != nil {
+		t.Fatalf("RegisterClient() error = %v", err)
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	assertion := sign(jwt.MapClaims{
+		"iss": "assertion-client",
+		"sub": "testuser",
+		"aud": "http://example.com/oauth2/token",
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"jti": "assertion-1",
+	})
+
+	form := url.Values{}
+	form.Set("grant_type", GrantTypeJWTBearer)
+	form.Set("assertion", assertion)
+	res := requestToken(t, form, nil)
+
+	claims := mustParseToken(t, res.AccessToken)
+	if claims["clientID"] != "assertion-client" || claims["username"] != "testuser" {
+		t.Errorf("jwt-bearer token claims = %v", claims)
+	}
+
+	w := exchangeCode(form)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "already been used") {
+		t.Errorf("replayed assertion: status = %v, body = %s", w.Code, w.Body.String())
+	}
+
+	form.Set("assertion", sign(jwt.MapClaims{
+		"iss": "assertion-client",
+		"sub": "testuser",
+		"aud": "https://elsewhere.example.com",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}))
+	w = exchangeCode(form)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), string(ErrCodeInvalidGrant)) {
+		t.Errorf("wrong audience: status = %v, body = %s", w.Code, w.Body.String())
+	}
+}
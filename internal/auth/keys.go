@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	SigningAlgHS256 = "HS256"
+	SigningAlgRS256 = "RS256"
+	SigningAlgES256 = "ES256"
+	SigningAlgEdDSA = "EdDSA"
+
+	defaultKeyRotationGrace = time.Hour
+)
+
+// SigningAlgorithm is the JWT signing algorithm used for issued tokens unless
+// a token request overrides it with the x-oauth2-signing-alg header.
+var SigningAlgorithm = SigningAlgHS256
+
+var asymmetricSigningAlgs = []string{SigningAlgRS256, SigningAlgES256, SigningAlgEdDSA}
+
+type signingKey struct {
+	Kid        string
+	Alg        string
+	PrivateKey crypto.Signer
+	// RetiredAt is zero while the key is used for signing. Retired keys are
+	// still accepted for verification until ExpiresAt.
+	RetiredAt time.Time
+	ExpiresAt time.Time
+}
+
+type keyring struct {
+	mu   sync.RWMutex
+	once sync.Once
+	keys []*signingKey
+}
+
+var signingKeys keyring
+
+func (k *keyring) init() {
+	k.once.Do(func() {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+
+		for _, alg := range asymmetricSigningAlgs {
+			key, err := generateSigningKey(alg)
+			if err != nil {
+				panic(err)
+			}
+			k.keys = append(k.keys, key)
+		}
+	})
+}
+
+func generateSigningKey(alg string) (*signingKey, error) {
+	var priv crypto.Signer
+	var err error
+
+	switch alg {
+	case SigningAlgRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case SigningAlgES256:
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case SigningAlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingKey{
+		Kid:        gofakeit.UUID(),
+		Alg:        alg,
+		PrivateKey: priv,
+	}, nil
+}
+
+// active returns the current signing key for alg.
+func (k *keyring) active(alg string) (*signingKey, error) {
+	k.init()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		if key := k.keys[i]; key.Alg == alg && key.RetiredAt.IsZero() {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no signing key for algorithm: %s", alg)
+}
+
+// lookup returns the key identified by kid if it is still valid for
+// verification.
+func (k *keyring) lookup(kid string) (*signingKey, bool) {
+	k.init()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	for _, key := range k.keys {
+		if key.Kid != kid {
+			continue
+		}
+		if !key.ExpiresAt.IsZero() && key.ExpiresAt.Before(now) {
+			return nil, false
+		}
+		return key, true
+	}
+
+	return nil, false
+}
+
+// verificationKeys returns every key that is currently valid for verification.
+func (k *keyring) verificationKeys() []*signingKey {
+	k.init()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	keys := make([]*signingKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		if key.ExpiresAt.IsZero() || key.ExpiresAt.After(now) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// RotateSigningKeys generates a new signing key for every asymmetric algorithm.
+// Previous keys stop being used for signing immediately but remain valid for
+// verification, and published in the JWKS, for the given grace period.
+func RotateSigningKeys(grace time.Duration) error {
+	signingKeys.init()
+
+	fresh := make([]*signingKey, 0, len(asymmetricSigningAlgs))
+	for _, alg := range asymmetricSigningAlgs {
+		key, err := generateSigningKey(alg)
+		if err != nil {
+			return err
+		}
+		fresh = append(fresh, key)
+	}
+
+	signingKeys.mu.Lock()
+	defer signingKeys.mu.Unlock()
+
+	now := time.Now()
+	kept := make([]*signingKey, 0, len(signingKeys.keys)+len(fresh))
+	for _, key := range signingKeys.keys {
+		if key.RetiredAt.IsZero() {
+			key.RetiredAt = now
+			key.ExpiresAt = now.Add(grace)
+		}
+		if key.ExpiresAt.After(now) {
+			kept = append(kept, key)
+		}
+	}
+	signingKeys.keys = append(kept, fresh...)
+
+	return nil
+}
+
+func ValidateSigningAlgorithm(alg string) error {
+	if alg != SigningAlgHS256 && !slices.Contains(asymmetricSigningAlgs, alg) {
+		return fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+
+	return nil
+}
+
+func signToken(claims jwt.MapClaims, alg string) (string, error) {
+	if alg == SigningAlgHS256 {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(oauth2JWTSigningSecret))
+	}
+
+	key, err := signingKeys.active(alg)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(alg), claims)
+	token.Header["kid"] = key.Kid
+
+	return token.SignedString(key.PrivateKey)
+}
+
+func verificationKey(token *jwt.Token) (any, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return []byte(oauth2JWTSigningSecret), nil
+	}
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok || kid == "" {
+		return nil, fmt.Errorf("missing kid header")
+	}
+
+	key, ok := signingKeys.lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown or expired signing key: %s", kid)
+	}
+	if key.Alg != token.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %s", token.Header["alg"], kid)
+	}
+
+	return key.PrivateKey.Public(), nil
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func publicJWK(key *signingKey) JWK {
+	jwk := JWK{
+		Use: "sig",
+		Alg: key.Alg,
+		Kid: key.Kid,
+	}
+
+	switch pub := key.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// Uncompressed point encoding: 0x04 || X || Y
+		point, err := pub.ECDH()
+		if err != nil {
+			panic(err)
+		}
+		raw := point.Bytes()[1:]
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = base64.RawURLEncoding.EncodeToString(raw[:len(raw)/2])
+		jwk.Y = base64.RawURLEncoding.EncodeToString(raw[len(raw)/2:])
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+
+	return jwk
+}
+
+func currentJWKS() JWKS {
+	keys := signingKeys.verificationKeys()
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(keys))}
+	for _, key := range keys {
+		jwks.Keys = append(jwks.Keys, publicJWK(key))
+	}
+
+	return jwks
+}
+
+func HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := enc.Encode(currentJWKS()); err != nil {
+		http.Error(w, `{"error": "failed to encode response"}`, http.StatusInternalServerError)
+		return
+	}
+}
+
+// HandleRotateSigningKeys rotates the asymmetric signing keys. The optional
+// grace_seconds query parameter controls how long the previous keys remain
+// valid for verification.
+func HandleRotateSigningKeys(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	w.Header().Set("Content-Type", "application/json")
+
+	grace := defaultKeyRotationGrace
+	if raw := r.URL.Query().Get("grace_seconds"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds < 0 {
+			http.Error(w, `{"error": "grace_seconds must be a non-negative integer"}`, http.StatusBadRequest)
+			return
+		}
+		grace = time.Duration(seconds) * time.Second
+	}
+
+	if err := RotateSigningKeys(grace); err != nil {
+		http.Error(w, `{"error": "failed to rotate signing keys"}`, http.StatusInternalServerError)
+		return
+	}
+
+	if err := enc.Encode(currentJWKS()); err != nil {
+		http.Error(w, `{"error": "failed to encode response"}`, http.StatusInternalServerError)
+		return
+	}
+}
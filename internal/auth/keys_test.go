@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSigningKeyRotation(t *testing.T) {
+	for _, alg := range asymmetricSigningAlgs {
+		t.Run(alg, func(t *testing.T) {
+			claims := jwt.MapClaims{"id": "token-" + alg}
+
+			before, err := signToken(claims, alg)
+			if err != nil {
+				t.Fatalf("signToken() error = %v", err)
+			}
+			if _, err := ParseToken(before); err != nil {
+				t.Fatalf("ParseToken() error = %v", err)
+			}
+
+			if err := RotateSigningKeys(time.Hour); err != nil {
+				t.Fatalf("RotateSigningKeys() error = %v", err)
+			}
+			if _, err := ParseToken(before); err != nil {
+				t.Errorf("token signed before rotation rejected during grace period: %v", err)
+			}
+
+			after, err := signToken(claims, alg)
+			if err != nil {
+				t.Fatalf("signToken() error = %v", err)
+			}
+			if _, err := ParseToken(after); err != nil {
+				t.Errorf("token signed after rotation rejected: %v", err)
+			}
+
+			// The second rotation retires the key used for `after` with no grace
+			// and the key used for `before` keeps its original grace period.
+			if err := RotateSigningKeys(0); err != nil {
+				t.Fatalf("RotateSigningKeys() error = %v", err)
+			}
+			if _, err := ParseToken(after); err == nil {
+				t.Errorf("token signed with an expired key was accepted")
+			}
+			if _, err := ParseToken(before); err != nil {
+				t.Errorf("token signed before rotation rejected during grace period: %v", err)
+			}
+		})
+	}
+}
+
+func TestCurrentJWKS(t *testing.T) {
+	jwks := currentJWKS()
+
+	kty := map[string]string{}
+	for _, key := range jwks.Keys {
+		if key.Kid == "" {
+			t.Errorf("JWK for %s is missing kid", key.Alg)
+		}
+		kty[key.Alg] = key.Kty
+	}
+
+	want := map[string]string{
+		SigningAlgRS256: "RSA",
+		SigningAlgES256: "EC",
+		SigningAlgEdDSA: "OKP",
+	}
+	for alg, wantKty := range want {
+		if kty[alg] != wantKty {
+			t.Errorf("JWKS key type for %s = %q, want %q", alg, kty[alg], wantKty)
+		}
+	}
+}
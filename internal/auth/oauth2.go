@@ -3,12 +3,12 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/brianvoe/gofakeit/v7"
@@ -19,6 +19,12 @@ const (
 	oauth2JWTSigningSecret = "fancy-jwt-signing-secret"
 )
 
+// RefreshTokenRotation controls whether refresh tokens are single use by
+// default. When enabled, every refresh invalidates the presented refresh token
+// and presenting it again revokes every token in its family. Individual token
+// requests can override this with the x-oauth2-refresh-rotation header.
+var RefreshTokenRotation bool
+
 type OAuth2ErrorCode string
 
 const (
@@ -28,33 +34,133 @@ const (
 	ErrCodeUnauthorizedClient   OAuth2ErrorCode = "unauthorized_client"
 	ErrCodeUnsupportedGrantType OAuth2ErrorCode = "unsupported_grant_type"
 	ErrCodeInvalidScope         OAuth2ErrorCode = "invalid_scope"
+
+	ErrCodeUnsupportedResponseType OAuth2ErrorCode = "unsupported_response_type"
+	ErrCodeServerError             OAuth2ErrorCode = "server_error"
+	ErrCodeTemporarilyUnavailable  OAuth2ErrorCode = "temporarily_unavailable"
+
+	// RFC 6750 errors returned by protected resources.
+	ErrCodeInvalidToken OAuth2ErrorCode = "invalid_token"
 )
 
+// realm is advertised in WWW-Authenticate challenges.
+const realm = "speakeasy-api-test-service"
+
+// ErrorURI, when set, is returned as the error_uri of every OAuth2 error with
+// the error code appended as a fragment. Individual requests can override it
+// with the x-oauth2-error-uri header.
+var ErrorURI string
+
 func (e OAuth2ErrorCode) Error() string {
 	return string(e)
 }
 
-type oauth2Error struct {
-	Code    OAuth2ErrorCode `json:"error"`
-	Message string          `json:"error_description,omitempty"`
+// StatusCode returns the HTTP status an error is sent with: 401 for failed
+// client or token authentication (RFC 6749 section 5.2, RFC 6750 section
+// 3.1), 403 for insufficient scope and 400 for every other request error.
+func (e OAuth2ErrorCode) StatusCode() int {
+	switch e {
+	case ErrCodeInvalidClient, ErrCodeInvalidToken:
+		return http.StatusUnauthorized
+	case ErrCodeInsufficientScope:
+		return http.StatusForbidden
+	case ErrCodeServerError:
+		return http.StatusInternalServerError
+	case ErrCodeTemporarilyUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// OAuth2Error is the error response body of RFC 6749 section 5.2.
+type OAuth2Error struct {
+	Code        OAuth2ErrorCode `json:"error"`
+	Description string          `json:"error_description,omitempty"`
+	URI         string          `json:"error_uri,omitempty"`
 }
 
-func SendOAuth2Error(w http.ResponseWriter, code OAuth2ErrorCode, description string) {
-	payload := oauth2Error{
-		Code:    code,
-		Message: description,
+func errorURI(r *http.Request, code OAuth2ErrorCode) string {
+	if uri := r.Header.Get("x-oauth2-error-uri"); uri != "" {
+		return uri
+	}
+	if ErrorURI == "" {
+		return ""
 	}
 
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusBadRequest)
-	if err := enc.Encode(payload); err != nil {
-		log.Println(err)
+	return ErrorURI + "#" + string(code)
+}
 
-		http.Error(w, `{"error": "failed to encode response"}`, http.StatusInternalServerError)
-		return
+// SendOAuth2Error writes an error response of the authorization server's
+// endpoints. invalid_client errors carry a Basic challenge, the only HTTP
+// authentication scheme clients can use with the token endpoint.
+func SendOAuth2Error(w http.ResponseWriter, r *http.Request, code OAuth2ErrorCode, description string) {
+	if code == ErrCodeInvalidClient {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, realm))
 	}
+
+	writeOAuth2Error(w, r, code.StatusCode(), OAuth2Error{
+		Code:        code,
+		Description: description,
+		URI:         errorURI(r, code),
+	})
+}
+
+// QuoteChallengeParam quotes v as the value of a WWW-Authenticate challenge
+// parameter. Quotes and backslashes are escaped, and characters outside those
+// RFC 6750 allows in error_description and scope are dropped.
+func QuoteChallengeParam(v string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range v {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c >= 0x20 && c <= 0x7e:
+			b.WriteRune(c)
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// SendBearerError writes an RFC 6750 error response of a protected resource
+// with a Bearer challenge. An empty code is used when the request carried no
+// token at all, in which case the challenge must not include error details.
+func SendBearerError(w http.ResponseWriter, r *http.Request, code OAuth2ErrorCode, description, scope string) {
+	status := http.StatusUnauthorized
+	challenge := fmt.Sprintf(`Bearer realm="%s"`, realm)
+	if code != "" {
+		status = code.StatusCode()
+		challenge += fmt.Sprintf(`, error="%s"`, code)
+		if description != "" {
+			challenge += ", error_description=" + QuoteChallengeParam(description)
+		}
+		if uri := errorURI(r, code); uri != "" {
+			challenge += fmt.Sprintf(`, error_uri="%s"`, uri)
+		}
+	}
+	if scope != "" {
+		challenge += ", scope=" + QuoteChallengeParam(scope)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+
+	if code == "" {
+		code = ErrCodeInvalidRequest
+	}
+	writeOAuth2Error(w, r, status, OAuth2Error{
+		Code:        code,
+		Description: description,
+		URI:         errorURI(r, code),
+	})
+}
+
+// writeOAuth2Error writes an error response in the format negotiated for
+// token responses.
+func writeOAuth2Error(w http.ResponseWriter, r *http.Request, status int, payload OAuth2Error) {
+	WriteTokenResponse(w, r, status, payload)
 }
 
 type TokenForm struct {
@@ -65,8 +171,21 @@ type TokenForm struct {
 	Password     string `json:"password"`
 	Code         string `json:"code"`
 	RedirectURI  string `json:"redirect_uri"`
+	CodeVerifier string `json:"code_verifier"`
+	DeviceCode   string `json:"device_code"`
 	RefreshToken string `json:"refresh_token"`
 	Scope        string `json:"scope"`
+
+	// Token exchange (RFC 8693) parameters
+	SubjectToken       string   `json:"subject_token"`
+	SubjectTokenType   string   `json:"subject_token_type"`
+	ActorToken         string   `json:"actor_token"`
+	ActorTokenType     string   `json:"actor_token_type"`
+	RequestedTokenType string   `json:"requested_token_type"`
+	Audience           []string `json:"audience"`
+
+	// JWT bearer (RFC 7523) assertion
+	Assertion string `json:"assertion"`
 }
 
 type OAuth2TokenResponse struct {
@@ -74,6 +193,10 @@ type OAuth2TokenResponse struct {
 	RefreshToken string `json:"refresh_token,omitempty"`
 	TokenType    string `json:"token_type"`
 	ExpiresIn    int    `json:"expires_in"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// IssuedTokenType is only set for token exchange responses.
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
 }
 
 func HandleOAuth2InspectToken(w http.ResponseWriter, r *http.Request) {
@@ -97,6 +220,10 @@ func HandleOAuth2InspectToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error": "invalid token"}`, http.StatusBadRequest)
 		return
 	}
+	if _, ok := TokenID(claims); !ok {
+		http.Error(w, `{"error": "invalid token"}`, http.StatusBadRequest)
+		return
+	}
 
 	updatedExpiry := GetTokenExpiry(claims)
 
@@ -109,95 +236,264 @@ func HandleOAuth2InspectToken(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleOAuth2(w http.ResponseWriter, r *http.Request) {
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-
-	w.Header().Set("Content-Type", "application/json")
-
 	defer r.Body.Close()
 
-	if err := r.ParseForm(); err != nil {
+	if err := ParseTokenRequest(r); err != nil {
 		log.Println(err)
-		SendOAuth2Error(w, ErrCodeInvalidRequest, "cannot parse url-encoded request body")
+		SendOAuth2Error(w, r, ErrCodeInvalidRequest, "cannot parse request body")
 		return
 	}
 
 	form := TokenForm{
 		GrantType:    r.PostForm.Get("grant_type"),
-		ClientID:     r.PostForm.Get("client_id"),
+		ClientID:     requestClientID(r),
 		ClientSecret: r.PostForm.Get("client_secret"),
 		Username:     r.PostForm.Get("username"),
 		Password:     r.PostForm.Get("password"),
 		Code:         r.PostForm.Get("code"),
 		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		DeviceCode:   r.PostForm.Get("device_code"),
 		RefreshToken: r.PostForm.Get("refresh_token"),
 		Scope:        r.PostForm.Get("scope"),
+
+		SubjectToken:       r.PostForm.Get("subject_token"),
+		SubjectTokenType:   r.PostForm.Get("subject_token_type"),
+		ActorToken:         r.PostForm.Get("actor_token"),
+		ActorTokenType:     r.PostForm.Get("actor_token_type"),
+		RequestedTokenType: r.PostForm.Get("requested_token_type"),
+		Audience:           r.PostForm["audience"],
+		Assertion:          r.PostForm.Get("assertion"),
+	}
+
+	now := requestNow(r)
+	family := gofakeit.UUID()
+	authTime := now
+	nonce := ""
+	issueRefreshToken := true
+	issuedTokenType := ""
+	var extraClaims jwt.MapClaims
+	rotateRefreshToken := RefreshTokenRotation
+	if rotate, err := strconv.ParseBool(r.Header.Get("x-oauth2-refresh-rotation")); err == nil {
+		rotateRefreshToken = rotate
 	}
 
+	var client Client
+	var refreshScope string
 	switch form.GrantType {
 	case "client_credentials":
-		if form.ClientID == "" || form.ClientSecret == "" {
-			SendOAuth2Error(w, ErrCodeInvalidRequest, "missing client credentials")
+		var err error
+		client, err = authenticateClient(r, form)
+		if errors.Is(err, errMissingClientCredentials) {
+			SendOAuth2Error(w, r, ErrCodeInvalidRequest, err.Error())
 			return
 		}
-
-		if !validateClientCredentials(r, form) {
-			SendOAuth2Error(w, ErrCodeInvalidClient, "invalid client id or secret")
+		if err != nil {
+			SendOAuth2Error(w, r, ErrCodeInvalidClient, err.Error())
 			return
 		}
 	case "password":
-		if form.ClientID == "" || form.ClientSecret == "" || form.Username == "" || form.Password == "" {
-			SendOAuth2Error(w, ErrCodeInvalidRequest, "missing resource owner password credentials")
+		if form.Username == "" || form.Password == "" {
+			SendOAuth2Error(w, r, ErrCodeInvalidRequest, "missing resource owner password credentials")
 			return
 		}
-		if !validateClientCredentials(r, form) {
-			SendOAuth2Error(w, ErrCodeInvalidClient, "invalid client id or secret")
+		var err error
+		client, err = authenticateClient(r, form)
+		if errors.Is(err, errMissingClientCredentials) {
+			SendOAuth2Error(w, r, ErrCodeInvalidRequest, "missing resource owner password credentials")
 			return
 		}
-		if form.Username != "testuser" || form.Password != "testpassword" {
-			SendOAuth2Error(w, ErrCodeInvalidGrant, "invalid username or password")
+		if err != nil {
+			SendOAuth2Error(w, r, ErrCodeInvalidClient, err.Error())
+			return
+		}
+		if !ValidUserCredentials(form.Username, form.Password) {
+			SendOAuth2Error(w, r, ErrCodeInvalidGrant, "invalid username or password")
 			return
 		}
 	case "authorization_code":
-		if form.ClientID == "" || form.Code == "" {
-			SendOAuth2Error(w, ErrCodeInvalidRequest, "missing authorization code credentials")
+		if form.Code == "" || form.ClientID == "" && !hasClientCredentials(r) {
+			SendOAuth2Error(w, r, ErrCodeInvalidRequest, "missing authorization code credentials")
+			return
+		}
+		var errCode OAuth2ErrorCode
+		var description string
+		client, errCode, description = authenticatePublicClient(r, form)
+		if errCode != "" {
+			SendOAuth2Error(w, r, errCode, description)
+			return
+		}
+		form.Username = defaultUsername
+		if form.Code != legacyAuthorizationCode {
+			code, errCode, description := redeemAuthorizationCode(form, client.ClientID)
+			if errCode != "" {
+				SendOAuth2Error(w, r, errCode, description)
+				return
+			}
+			form.Scope = code.Scope
+			form.Username = code.Username
+			nonce = code.Nonce
+			authTime = code.AuthTime
+		}
+	case GrantTypeDeviceCode:
+		if form.DeviceCode == "" || form.ClientID == "" && !hasClientCredentials(r) {
+			SendOAuth2Error(w, r, ErrCodeInvalidRequest, "missing device code credentials")
+			return
+		}
+		var errCode OAuth2ErrorCode
+		var description string
+		client, errCode, description = authenticatePublicClient(r, form)
+		if errCode != "" {
+			SendOAuth2Error(w, r, errCode, description)
+			return
+		}
+		device, errCode, description := redeemDeviceCode(form, client.ClientID)
+		if errCode != "" {
+			SendOAuth2Error(w, r, errCode, description)
+			return
+		}
+		form.Scope = device.Scope
+		form.Username = device.username
+		authTime = device.authTime
+	case GrantTypeTokenExchange:
+		var err error
+		client, err = authenticateClient(r, form)
+		if err != nil {
+			SendOAuth2Error(w, r, ErrCodeInvalidClient, err.Error())
+			return
+		}
+		form.ClientID = client.ClientID
+
+		identity, errCode, description := exchangeTokens(form)
+		if errCode != "" {
+			SendOAuth2Error(w, r, errCode, description)
 			return
 		}
-		if form.ClientID != "beezy" {
-			SendOAuth2Error(w, ErrCodeInvalidClient, "invalid client id")
+		form.Username = identity.Username
+		form.Scope = identity.Scope
+		extraClaims = identity.Claims
+		issueRefreshToken = false
+		issuedTokenType = TokenTypeAccessToken
+		if form.RequestedTokenType == TokenTypeJWT {
+			issuedTokenType = TokenTypeJWT
+		}
+	case GrantTypeJWTBearer:
+		identity, errCode, description := redeemJWTBearerAssertion(r, form)
+		if errCode != "" {
+			SendOAuth2Error(w, r, errCode, description)
 			return
 		}
-		if form.Code != "secret-auth-code" {
-			SendOAuth2Error(w, ErrCodeInvalidGrant, "")
+		var ok bool
+		if client, ok = LookupClient(identity.ClientID); !ok {
+			SendOAuth2Error(w, r, ErrCodeInvalidClient, "assertion issuer is not a registered client")
 			return
 		}
+		form.Username = identity.Username
+		form.Scope = identity.Scope
+		issueRefreshToken = false
 	case "refresh_token":
 		if form.RefreshToken == "" {
-			SendOAuth2Error(w, ErrCodeInvalidRequest, "missing refresh token")
+			SendOAuth2Error(w, r, ErrCodeInvalidRequest, "missing refresh token")
 			return
 		}
 
-		if !validateClientCredentials(r, form) {
-			SendOAuth2Error(w, ErrCodeInvalidGrant, "invalid client id or secret")
-			return
+		// Public clients redeem the refresh tokens of their code and device
+		// grants without authenticating, like the grants themselves.
+		if registered, ok := LookupClient(form.ClientID); ok && registered.TokenEndpointAuthMethod == AuthMethodNone {
+			var errCode OAuth2ErrorCode
+			var description string
+			client, errCode, description = authenticatePublicClient(r, form)
+			if errCode != "" {
+				SendOAuth2Error(w, r, errCode, description)
+				return
+			}
+		} else {
+			var err error
+			client, err = authenticateClient(r, form)
+			if err != nil {
+				SendOAuth2Error(w, r, ErrCodeInvalidClient, err.Error())
+				return
+			}
 		}
 
 		rt, err := ParseToken(form.RefreshToken)
 		if err != nil {
-			SendOAuth2Error(w, ErrCodeInvalidRequest, "invalid refresh token")
+			SendOAuth2Error(w, r, ErrCodeInvalidRequest, "invalid refresh token")
+			return
+		}
+		if rt["grantType"] != "refresh_token" {
+			SendOAuth2Error(w, r, ErrCodeInvalidGrant, "not a refresh token")
+			return
+		}
+		if rt["clientID"] != client.ClientID {
+			SendOAuth2Error(w, r, ErrCodeInvalidGrant, "refresh token was issued to another client")
+			return
+		}
+		if IsTokenRevoked(rt) {
+			SendOAuth2Error(w, r, ErrCodeInvalidGrant, "refresh token has been revoked")
 			return
 		}
 		if IsTokenExpired(rt) {
-			SendOAuth2Error(w, ErrCodeInvalidGrant, "refresh token has expired")
+			SendOAuth2Error(w, r, ErrCodeInvalidGrant, "refresh token has expired")
+			return
+		}
+		if err := RefreshToken(rt); err != nil {
+			SendOAuth2Error(w, r, ErrCodeInvalidGrant, err.Error())
 			return
 		}
+
+		family = tokenFamily(rt)
+		form.Username, _ = rt["username"].(string)
+
+		// The access token may be downscoped but the new refresh token keeps
+		// the scope of the original grant (RFC 6749 section 6).
+		refreshScope, _ = rt["scope"].(string)
+		scope, ok := downscope(refreshScope, form.Scope)
+		if !ok {
+			SendOAuth2Error(w, r, ErrCodeInvalidScope, "requested scope exceeds the scope of the refresh token")
+			return
+		}
+		form.Scope = scope
+		if at, ok := rt["authTime"].(float64); ok {
+			authTime = time.Unix(int64(at), 0)
+		}
+		if rotate, ok := rt["rotate"].(bool); ok && rotate {
+			rotateRefreshToken = true
+		}
 	default:
-		SendOAuth2Error(w, ErrCodeUnsupportedGrantType, "unsupported grant type")
+		SendOAuth2Error(w, r, ErrCodeUnsupportedGrantType, "unsupported grant type")
+		return
+	}
+
+	form.ClientID = client.ClientID
+	if !client.AllowsGrant(form.GrantType) {
+		SendOAuth2Error(w, r, ErrCodeUnauthorizedClient, "client is not allowed to use this grant type")
+		return
+	}
+	if !client.AllowsGrant("refresh_token") {
+		issueRefreshToken = false
+	}
+
+	scope, errCode, description := client.GrantScope(form.Scope)
+	if errCode != "" {
+		SendOAuth2Error(w, r, errCode, description)
+		return
+	}
+	form.Scope = scope
+	if refreshScope == "" {
+		refreshScope = form.Scope
+	}
+
+	signingAlg := SigningAlgorithm
+	if alg := r.Header.Get("x-oauth2-signing-alg"); alg != "" {
+		signingAlg = alg
+	}
+	if err := ValidateSigningAlgorithm(signingAlg); err != nil {
+		SendOAuth2Error(w, r, ErrCodeInvalidRequest, err.Error())
 		return
 	}
 
-	now := time.Now()
-	expires := now.Add(time.Hour)
+	expires := now.Add(client.accessTokenLifetime())
 	forcedExpiry := r.Header.Get("x-oauth2-expire-at")
 	if exp, err := time.Parse(time.RFC3339, forcedExpiry); err == nil {
 		expires = exp
@@ -206,93 +502,181 @@ func HandleOAuth2(w http.ResponseWriter, r *http.Request) {
 	accessTokenID := gofakeit.UUID()
 	accessTokenClaims := jwt.MapClaims{
 		"exp":       float64(expires.Unix()),
+		"iat":       float64(now.Unix()),
 		"id":        accessTokenID,
 		"grantType": form.GrantType,
 		"clientID":  form.ClientID,
 		"username":  form.Username,
 		"scope":     form.Scope,
+		"family":    family,
 	}
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims)
-	signedAccessToken, err := accessToken.SignedString([]byte(oauth2JWTSigningSecret))
-	if err != nil {
-		log.Println(err)
-		SendOAuth2Error(w, ErrCodeInvalidRequest, err.Error())
-		return
+	if session := requestSession(r); session != "" {
+		accessTokenClaims["session"] = session
 	}
-
-	refreshTokenClaims := jwt.MapClaims{
-		"exp":       float64(now.Add(60 * 24 * time.Hour).Unix()),
-		"id":        gofakeit.UUID(),
-		"sub":       accessTokenID,
-		"grantType": "refresh_token",
-		"clientID":  form.ClientID,
-		"username":  form.Username,
-		"scope":     form.Scope,
+	for k, v := range extraClaims {
+		accessTokenClaims[k] = v
 	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshTokenClaims)
-	signedRefreshToken, err := refreshToken.SignedString([]byte(oauth2JWTSigningSecret))
+	signedAccessToken, err := signToken(accessTokenClaims, signingAlg)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, `{"error": "failed to sign refresh token"}`, http.StatusInternalServerError)
+		SendOAuth2Error(w, r, ErrCodeServerError, "failed to sign access token")
 		return
 	}
 
 	res := OAuth2TokenResponse{
-		AccessToken:  signedAccessToken,
-		RefreshToken: signedRefreshToken,
-		TokenType:    "Bearer",
-		ExpiresIn:    max(int(expires.Sub(now).Seconds()), 0),
+		AccessToken:     signedAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       max(int(expires.Sub(now).Seconds()), 0),
+		Scope:           form.Scope,
+		IssuedTokenType: issuedTokenType,
 	}
 
-	RegisterToken(accessTokenClaims)
-	RegisterToken(refreshTokenClaims)
+	var refreshTokenClaims jwt.MapClaims
+	if issueRefreshToken {
+		refreshTokenClaims = jwt.MapClaims{
+			"exp":       float64(now.Add(client.refreshTokenLifetime()).Unix()),
+			"iat":       float64(now.Unix()),
+			"id":        gofakeit.UUID(),
+			"sub":       accessTokenID,
+			"grantType": "refresh_token",
+			"clientID":  form.ClientID,
+			"username":  form.Username,
+			"scope":     refreshScope,
+			"family":    family,
+			"rotate":    rotateRefreshToken,
+			"authTime":  float64(authTime.Unix()),
+		}
+		if session := requestSession(r); session != "" {
+			refreshTokenClaims["session"] = session
+		}
+		res.RefreshToken, err = signToken(refreshTokenClaims, signingAlg)
+		if err != nil {
+			log.Println(err)
+			SendOAuth2Error(w, r, ErrCodeServerError, "failed to sign refresh token")
+			return
+		}
+	}
 
-	if err := enc.Encode(res); err != nil {
-		http.Error(w, `{"error": "failed to encode response"}`, http.StatusInternalServerError)
-		return
+	if hasScope(form.Scope, ScopeOpenID) && form.Username != "" {
+		res.IDToken, err = issueIDToken(r, signingAlg, form.ClientID, form.Username, nonce, authTime, now, expires)
+		if err != nil {
+			log.Println(err)
+			SendOAuth2Error(w, r, ErrCodeServerError, "failed to sign id token")
+			return
+		}
 	}
-}
 
-func validateClientCredentials(r *http.Request, form TokenForm) bool {
-	clientID := form.ClientID
-	clientSecret := form.ClientSecret
-	if clientID == "" && clientSecret == "" {
-		clientID, clientSecret, _ = r.BasicAuth()
+	RegisterToken(accessTokenClaims)
+	if refreshTokenClaims != nil {
+		RegisterToken(refreshTokenClaims)
 	}
 
-	return clientID == "beezy" && clientSecret == "super-secret"
+	WriteTokenResponse(w, r, http.StatusOK, res)
+}
+
+type tokenRecord struct {
+	Expiry time.Time
+	Family string
+	// Rotated is set once a rotating refresh token has been exchanged.
+	Rotated bool
+	Revoked bool
+}
+
+// tokenSession returns the token store of the session a token was issued in.
+func tokenSession(tokenClaims jwt.MapClaims) *tokenStore {
+	session, _ := tokenClaims["session"].(string)
+	return sessionTokens(session)
 }
 
-var tokenDB sync.Map
-var tokenDBLastAccess atomic.Value
+// TokenID returns the ID of a token tracked in the token store. Tokens
+// without one, such as ID tokens, are not tracked.
+func TokenID(tokenClaims jwt.MapClaims) (string, bool) {
+	tokenID, ok := tokenClaims["id"].(string)
+	return tokenID, ok && tokenID != ""
+}
 
 func RegisterToken(tokenClaims jwt.MapClaims) {
-	tokenDBLastAccess.Store(time.Now())
+	store := tokenSession(tokenClaims)
 
-	tokenID := tokenClaims["id"].(string)
+	tokenID, ok := TokenID(tokenClaims)
+	if !ok {
+		return
+	}
 	expiry, err := tokenClaims.GetExpirationTime()
 	if err != nil {
 		panic(err)
 	}
-	tokenDB.Store(tokenID, expiry.Time)
+	store.tokens.Store(tokenID, tokenRecord{
+		Expiry: expiry.Time,
+		Family: tokenFamily(tokenClaims),
+	})
 }
 
-func RefreshToken(refreshClaims jwt.MapClaims) {
-	tokenDBLastAccess.Store(time.Now())
+// loadTokenRecord returns the record of a tracked token, registering it if
+// it is not known yet.
+func loadTokenRecord(tokenClaims jwt.MapClaims) (tokenRecord, bool) {
+	store := tokenSession(tokenClaims)
+	tokenID, ok := TokenID(tokenClaims)
+	if !ok {
+		return tokenRecord{}, false
+	}
 
-	tokenID := refreshClaims["sub"].(string)
-	expiry := time.Now().Add(time.Hour)
-	tokenDB.Store(tokenID, expiry)
+	rec, found := store.tokens.Load(tokenID)
+	if !found {
+		RegisterToken(tokenClaims)
+		rec, _ = store.tokens.Load(tokenID)
+	}
+
+	return rec.(tokenRecord), true
 }
 
-func GetTokenExpiry(tokenClaims jwt.MapClaims) time.Time {
-	tokenDBLastAccess.Store(time.Now())
+// tokenFamily returns the identifier shared by every token issued from the
+// same original grant. Tokens minted before families existed form a family of
+// their own.
+func tokenFamily(tokenClaims jwt.MapClaims) string {
+	if family, ok := tokenClaims["family"].(string); ok && family != "" {
+		return family
+	}
+
+	tokenID, _ := TokenID(tokenClaims)
+	return tokenID
+}
+
+// RefreshToken records that the given refresh token has been exchanged for a
+// new token pair. Rotating refresh tokens may only be exchanged once; a second
+// exchange is treated as token theft and revokes the entire token family.
+func RefreshToken(refreshClaims jwt.MapClaims) error {
+	if rotate, ok := refreshClaims["rotate"].(bool); !ok || !rotate {
+		return nil
+	}
+
+	store := tokenSession(refreshClaims)
+	tokenID, _ := TokenID(refreshClaims)
+	rec, ok := loadTokenRecord(refreshClaims)
+	if !ok {
+		return errors.New("refresh token is not tracked")
+	}
+	if rec.Rotated {
+		RevokeTokenFamily(refreshClaims)
+		return errors.New("refresh token reuse detected, token family revoked")
+	}
 
-	tokenID := tokenClaims["id"].(string)
+	rotated := rec
+	rotated.Rotated = true
+	if !store.tokens.CompareAndSwap(tokenID, rec, rotated) {
+		// Lost a race with a concurrent exchange of the same token.
+		RevokeTokenFamily(refreshClaims)
+		return errors.New("refresh token reuse detected, token family revoked")
+	}
+
+	return nil
+}
 
-	exp, found := tokenDB.Load(tokenID)
-	if found {
-		return exp.(time.Time)
+func GetTokenExpiry(tokenClaims jwt.MapClaims) time.Time {
+	if tokenID, ok := TokenID(tokenClaims); ok {
+		if rec, found := tokenSession(tokenClaims).tokens.Load(tokenID); found {
+			return rec.(tokenRecord).Expiry
+		}
 	}
 
 	expiryClaim, err := tokenClaims.GetExpirationTime()
@@ -303,24 +687,63 @@ func GetTokenExpiry(tokenClaims jwt.MapClaims) time.Time {
 	return expiryClaim.Time
 }
 
+// IsTokenExpired reports whether a token has expired. Untracked tokens
+// count as expired.
 func IsTokenExpired(tokenClaims jwt.MapClaims) bool {
-	tokenDBLastAccess.Store(time.Now())
+	rec, ok := loadTokenRecord(tokenClaims)
+	return !ok || rec.Expiry.Before(tokenNow(tokenClaims))
+}
 
-	tokenID := tokenClaims["id"].(string)
-	expiryClaim, err := tokenClaims.GetExpirationTime()
-	if err != nil {
-		panic(err)
-	}
+// IsTokenRevoked reports whether a token has been revoked. Untracked tokens
+// count as revoked.
+func IsTokenRevoked(tokenClaims jwt.MapClaims) bool {
+	rec, ok := loadTokenRecord(tokenClaims)
+	return !ok || rec.Revoked
+}
 
-	exp, found := tokenDB.Load(tokenID)
-	if !found {
-		RegisterToken(tokenClaims)
-		exp = expiryClaim.Time
+func RevokeToken(tokenClaims jwt.MapClaims) {
+	store := tokenSession(tokenClaims)
+	tokenID, ok := TokenID(tokenClaims)
+	if !ok {
+		return
 	}
+	for {
+		rec, _ := loadTokenRecord(tokenClaims)
+		revoked := rec
+		revoked.Revoked = true
+		if store.tokens.CompareAndSwap(tokenID, rec, revoked) {
+			return
+		}
+	}
+}
 
-	expiry := exp.(time.Time)
+// RevokeTokenFamily revokes every token issued from the same grant as the
+// given token.
+func RevokeTokenFamily(tokenClaims jwt.MapClaims) {
+	store := tokenSession(tokenClaims)
+	family := tokenFamily(tokenClaims)
+	if family == "" {
+		return
+	}
 
-	return expiry.Before(time.Now())
+	store.tokens.Range(func(key, value any) bool {
+		// Compare and swap, so that a concurrent rotation of the same record
+		// does not undo the revocation.
+		rec := value.(tokenRecord)
+		for rec.Family == family && !rec.Revoked {
+			revoked := rec
+			revoked.Revoked = true
+			if store.tokens.CompareAndSwap(key, rec, revoked) {
+				break
+			}
+			v, ok := store.tokens.Load(key)
+			if !ok {
+				break
+			}
+			rec = v.(tokenRecord)
+		}
+		return true
+	})
 }
 
 func StartTokenDBCompaction(ctx context.Context) {
@@ -331,29 +754,48 @@ func StartTokenDBCompaction(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			lastAccess, ok := tokenDBLastAccess.Load().(time.Time)
 			now := time.Now()
-			if !ok {
-				lastAccess = now
-			}
 
-			delta := now.Sub(lastAccess)
-			if delta > 5*time.Minute {
-				tokenDB.Clear()
-			}
+			evictTokenSessions()
+			purgeCookieSessions()
+
+			authCodeDB.Range(func(key, value any) bool {
+				code := value.(*authorizationCode)
+				if code.ExpiresAt.Before(clockFor(code.session).Now()) {
+					authCodeDB.Delete(key)
+				}
+				return true
+			})
+
+			usedAssertionIDs.Range(func(key, value any) bool {
+				if value.(time.Time).Add(clientAssertionLeeway).Before(now) {
+					usedAssertionIDs.Delete(key)
+				}
+				return true
+			})
+
+			digestNonces.Range(func(key, value any) bool {
+				if value.(*digestNonce).expiresAt.Before(now) {
+					digestNonces.Delete(key)
+				}
+				return true
+			})
+
+			deviceCodeDB.Range(func(key, value any) bool {
+				if device := value.(*deviceAuthorization); device.ExpiresAt.Before(clockFor(device.session).Now()) {
+					deviceCodeDB.Delete(key)
+					userCodeDB.Delete(device.UserCode)
+				}
+				return true
+			})
 		}
 	}
 }
 
 func ParseToken(encodedToken string) (jwt.MapClaims, error) {
-	parser := jwt.NewParser(jwt.WithoutClaimsValidation(), jwt.WithValidMethods([]string{"HS256"}))
-	token, err := parser.Parse(encodedToken, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(oauth2JWTSigningSecret), nil
-	})
+	validMethods := append([]string{SigningAlgHS256}, asymmetricSigningAlgs...)
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation(), jwt.WithValidMethods(validMethods))
+	token, err := parser.Parse(encodedToken, verificationKey)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func requestToken(t *testing.T, form url.Values, headers map[string]string) OAuth2TokenResponse {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	HandleOAuth2(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandleOAuth2() status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var res OAuth2TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode token response: %v", err)
+	}
+
+	return res
+}
+
+func clientCredentialsForm() url.Values {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", "beezy")
+	form.Set("client_secret", "super-secret")
+	return form
+}
+
+func refreshForm(refreshToken string) url.Values {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("client_id", "beezy")
+	form.Set("client_secret", "super-secret")
+	form.Set("refresh_token", refreshToken)
+	return form
+}
+
+func mustParseToken(t *testing.T, token string) map[string]any {
+	t.Helper()
+
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatalf("ParseToken() error = %v", err)
+	}
+	return claims
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	rotation := map[string]string{"x-oauth2-refresh-rotation": "true"}
+	first := requestToken(t, clientCredentialsForm(), rotation)
+	second := requestToken(t, refreshForm(first.RefreshToken), nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(refreshForm(first.RefreshToken).Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	HandleOAuth2(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), string(ErrCodeInvalidGrant)) {
+		t.Fatalf("reused refresh token: status = %v, body = %s", w.Code, w.Body.String())
+	}
+
+	for name, token := range map[string]string{
+		"first access token":   first.AccessToken,
+		"second access token":  second.AccessToken,
+		"second refresh token": second.RefreshToken,
+	} {
+		if !IsTokenRevoked(mustParseToken(t, token)) {
+			t.Errorf("%s was not revoked after refresh token reuse", name)
+		}
+	}
+}
+
+func TestRefreshTokenWithoutRotation(t *testing.T) {
+	first := requestToken(t, clientCredentialsForm(), nil)
+	requestToken(t, refreshForm(first.RefreshToken), nil)
+	requestToken(t, refreshForm(first.RefreshToken), nil)
+}
+
+func revoke(token, hint string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", hint)
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/revoke", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("beezy", "super-secret")
+	w := httptest.NewRecorder()
+	HandleOAuth2Revoke(w, req)
+	return w
+}
+
+func TestHandleOAuth2Revoke(t *testing.T) {
+	tokens := requestToken(t, clientCredentialsForm(), nil)
+
+	if w := revoke(tokens.RefreshToken, "refresh_token"); w.Code != http.StatusOK {
+		t.Fatalf("HandleOAuth2Revoke() status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if !IsTokenRevoked(mustParseToken(t, tokens.AccessToken)) {
+		t.Errorf("access token was not revoked with its refresh token")
+	}
+
+	// Unknown hints are ignored.
+	tokens = requestToken(t, clientCredentialsForm(), nil)
+	if w := revoke(tokens.AccessToken, "unknown_hint"); w.Code != http.StatusOK {
+		t.Fatalf("HandleOAuth2Revoke() with unknown hint status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if !IsTokenRevoked(mustParseToken(t, tokens.AccessToken)) {
+		t.Errorf("access token was not revoked with an unknown hint")
+	}
+
+	form := clientCredentialsForm()
+	form.Set("grant_type", "password")
+	form.Set("username", "testuser")
+	form.Set("password", "testpassword")
+	form.Set("scope", "openid")
+	idToken := requestToken(t, form, nil).IDToken
+	if w := revoke(idToken, ""); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), string(ErrCodeUnsupportedTokenType)) {
+		t.Errorf("HandleOAuth2Revoke() with id_token = %v %s, want unsupported_token_type", w.Code, w.Body.String())
+	}
+}
+
+func TestRevokeTokenFamilyDuringRefresh(t *testing.T) {
+	rotation := map[string]string{"x-oauth2-refresh-rotation": "true"}
+	for i := 0; i < 50; i++ {
+		refresh := mustParseToken(t, requestToken(t, clientCredentialsForm(), rotation).RefreshToken)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = RefreshToken(refresh)
+		}()
+		RevokeTokenFamily(refresh)
+		<-done
+
+		if !IsTokenRevoked(refresh) {
+			t.Fatal("revocation was lost to a concurrent refresh")
+		}
+	}
+}
+
+func TestHandleOAuth2Introspect(t *testing.T) {
+	tokens := requestToken(t, clientCredentialsForm(), nil)
+
+	introspect := func(token string) IntrospectionResponse {
+		t.Helper()
+
+		form := url.Values{}
+		form.Set("token", token)
+		req := httptest.NewRequest(http.MethodPost, "/oauth2/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("beezy", "super-secret")
+		w := httptest.NewRecorder()
+		HandleOAuth2Introspect(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("HandleOAuth2Introspect() status = %v, want %v: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+
+		var res IntrospectionResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("failed to decode introspection response: %v", err)
+		}
+		return res
+	}
+
+	res := introspect(tokens.AccessToken)
+	if !res.Active || res.ClientID != "beezy" || res.Exp == 0 || res.Iat == 0 {
+		t.Errorf("introspect(access token) = %+v, want active token for beezy", res)
+	}
+
+	if res := introspect("not-a-token"); res.Active {
+		t.Errorf("introspect(garbage) = %+v, want inactive", res)
+	}
+
+	RevokeToken(mustParseToken(t, tokens.AccessToken))
+	if res := introspect(tokens.AccessToken); res.Active {
+		t.Errorf("introspect(revoked token) = %+v, want inactive", res)
+	}
+}
+
+func TestOAuth2ErrorResponses(t *testing.T) {
+	tests := []struct {
+		name          string
+		setupRequest  func() *http.Request
+		wantStatus    int
+		wantCode      OAuth2ErrorCode
+		wantChallenge string
+		wantURI       string
+	}{
+		{
+			name: "invalid client secret with basic auth",
+			setupRequest: func() *http.Request {
+				form := url.Values{}
+				form.Set("grant_type", "client_credentials")
+				req := tokenEndpointRequest(form)
+				req.SetBasicAuth("beezy", "wrong")
+				return req
+			},
+			wantStatus:    http.StatusUnauthorized,
+			wantCode:      ErrCodeInvalidClient,
+			wantChallenge: `Basic realm="speakeasy-api-test-service"`,
+		},
+		{
+			name: "unsupported grant type with error uri",
+			setupRequest: func() *http.Request {
+				form := url.Values{}
+				form.Set("grant_type", "magic")
+				req := tokenEndpointRequest(form)
+				req.Header.Set("x-oauth2-error-uri", "https://example.com/errors")
+				return req
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   ErrCodeUnsupportedGrantType,
+			wantURI:    "https://example.com/errors",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			HandleOAuth2(w, tt.setupRequest())
+
+			var res OAuth2Error
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantStatus || res.Code != tt.wantCode {
+				t.Errorf("HandleOAuth2() = %v %v, want %v %v", w.Code, res.Code, tt.wantStatus, tt.wantCode)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != tt.wantChallenge {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.wantChallenge)
+			}
+			if res.URI != tt.wantURI {
+				t.Errorf("error_uri = %q, want %q", res.URI, tt.wantURI)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+		})
+	}
+}
+
+func TestIDTokenAsBearerToken(t *testing.T) {
+	form := url.Values{}
+	form.Set("grant_type", "password")
+	form.Set("client_id", "beezy")
+	form.Set("client_secret", "super-secret")
+	form.Set("username", "testuser")
+	form.Set("password", "testpassword")
+	form.Set("scope", "openid")
+	res := requestToken(t, form, nil)
+	if res.IDToken == "" {
+		t.Fatalf("token response is missing id_token")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/oauth2/token", nil)
+	req.Header.Set("Authorization", "Bearer "+res.IDToken)
+	w := httptest.NewRecorder()
+	HandleOAuth2InspectToken(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("HandleOAuth2InspectToken() status = %v, want %v: %s", w.Code, http.StatusBadRequest, w.Body.String())
+	}
+
+	claims := mustParseToken(t, res.IDToken)
+	if !IsTokenExpired(claims) || !IsTokenRevoked(claims) {
+		t.Errorf("id_token is accepted as a tracked token")
+	}
+	if introspectToken(res.IDToken).Active {
+		t.Errorf("id_token introspects as active")
+	}
+}
+
+func TestQuoteChallengeParam(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "read write", want: `"read write"`},
+		{value: `unknown user "bob"`, want: `"unknown user \"bob\""`},
+		{value: `a\b`, want: `"a\\b"`},
+		{value: "line\r\nbreak\tand ünicode", want: `"linebreakand nicode"`},
+	}
+
+	for _, tt := range tests {
+		if got := QuoteChallengeParam(tt.value); got != tt.want {
+			t.Errorf("QuoteChallengeParam(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+type OpenIDConfiguration struct {
+	Issuer                                     string   `json:"issuer"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint"`
+	DeviceAuthorizationEndpoint                string   `json:"device_authorization_endpoint"`
+	TokenEndpoint                              string   `json:"token_endpoint"`
+	UserInfoEndpoint                           string   `json:"userinfo_endpoint"`
+	JWKSURI                                    string   `json:"jwks_uri"`
+	RevocationEndpoint                         string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint                      string   `json:"introspection_endpoint"`
+	RegistrationEndpoint                       string   `json:"registration_endpoint"`
+	ScopesSupported                            []string `json:"scopes_supported"`
+	ResponseTypesSupported                     []string `json:"response_types_supported"`
+	GrantTypesSupported                        []string `json:"grant_types_supported"`
+	SubjectTypesSupported                      []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported           []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                            []string `json:"claims_supported"`
+}
+
+// issuerURL derives the issuer from the incoming request so that discovery
+// works regardless of the host and port the service is exposed on.
+func issuerURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	return scheme + "://" + r.Host
+}
+
+func HandleOpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	w.Header().Set("Content-Type", "application/json")
+
+	issuer := issuerURL(r)
+	config := OpenIDConfiguration{
+		Issuer:                      issuer,
+		AuthorizationEndpoint:       issuer + "/oauth2/authorize",
+		DeviceAuthorizationEndpoint: issuer + "/oauth2/device_authorization",
+		TokenEndpoint:               issuer + "/oauth2/token",
+		UserInfoEndpoint:            issuer + "/userinfo",
+		JWKSURI:                     issuer + "/.well-known/jwks.json",
+		RevocationEndpoint:          issuer + "/oauth2/revoke",
+		IntrospectionEndpoint:       issuer + "/oauth2/introspect",
+		RegistrationEndpoint:        issuer + "/oauth2/register",
+		ScopesSupported:             []string{ScopeOpenID, ScopeProfile, ScopeEmail},
+		ResponseTypesSupported:      []string{"code"},
+		GrantTypesSupported: []string{
+			"authorization_code",
+			"client_credentials",
+			"password",
+			"refresh_token",
+			GrantTypeDeviceCode,
+			GrantTypeTokenExchange,
+			GrantTypeJWTBearer,
+		},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: asymmetricSigningAlgs,
+		TokenEndpointAuthMethodsSupported: []string{
+			AuthMethodClientSecretBasic,
+			AuthMethodClientSecretPost,
+			AuthMethodClientSecretJWT,
+			AuthMethodPrivateKeyJWT,
+			AuthMethodTLSClientAuth,
+		},
+		TokenEndpointAuthSigningAlgValuesSupported: append(clientSecretJWTAlgs, assertionSigningAlgs...),
+		CodeChallengeMethodsSupported:              []string{CodeChallengeMethodPlain, CodeChallengeMethodS256},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce",
+			"name", "given_name", "family_name", "preferred_username",
+			"email", "email_verified",
+		},
+	}
+
+	if err := enc.Encode(config); err != nil {
+		http.Error(w, `{"error": "failed to encode response"}`, http.StatusInternalServerError)
+		return
+	}
+}
+
+func hasScope(scope, want string) bool {
+	return slices.Contains(strings.Fields(scope), want)
+}
+
+// issueIDToken signs an OpenID Connect ID token for the given user. ID tokens
+// must be verifiable by the client, so they are never signed with the server's
+// HMAC secret; RS256 is used when asymmetric signing is not configured.
+func issueIDToken(r *http.Request, alg, clientID, username, nonce string, authTime, now, expires time.Time) (string, error) {
+	if alg == SigningAlgHS256 {
+		alg = SigningAlgRS256
+	}
+
+	claims := jwt.MapClaims{
+		"iss":       issuerURL(r),
+		"sub":       username,
+		"aud":       clientID,
+		"exp":       float64(expires.Unix()),
+		"iat":       float64(now.Unix()),
+		"auth_time": float64(authTime.Unix()),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	return signToken(claims, alg)
+}
+
+// UserInfo returns the OpenID Connect userinfo claims released by an access
+// token. Profile and email claims are only released for their scopes.
+func UserInfo(tokenClaims jwt.MapClaims) (map[string]any, bool) {
+	username, _ := tokenClaims["username"].(string)
+	profile, ok := LookupUser(username)
+	if !ok {
+		return nil, false
+	}
+
+	scope, _ := tokenClaims["scope"].(string)
+
+	info := map[string]any{
+		"sub": profile.Username,
+	}
+	if hasScope(scope, ScopeProfile) {
+		info["name"] = profile.Name
+		info["given_name"] = profile.GivenName
+		info["family_name"] = profile.FamilyName
+		info["preferred_username"] = profile.Username
+	}
+	if hasScope(scope, ScopeEmail) {
+		info["email"] = profile.Email
+		info["email_verified"] = profile.EmailVerified
+	}
+
+	return info, true
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/gorilla/mux"
+)
+
+const (
+	ErrCodeInvalidRedirectURI    OAuth2ErrorCode = "invalid_redirect_uri"
+	ErrCodeInvalidClientMetadata OAuth2ErrorCode = "invalid_client_metadata"
+)
+
+var supportedAuthMethods = []string{
+	AuthMethodNone,
+	AuthMethodClientSecretBasic,
+	AuthMethodClientSecretPost,
+	AuthMethodClientSecretJWT,
+	AuthMethodPrivateKeyJWT,
+	AuthMethodTLSClientAuth,
+}
+
+var supportedGrantTypes = []string{
+	"authorization_code",
+	"client_credentials",
+	"password",
+	"refresh_token",
+	GrantTypeDeviceCode,
+	GrantTypeTokenExchange,
+	GrantTypeJWTBearer,
+}
+
+func validRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	return err == nil && u.IsAbs() && u.Fragment == ""
+}
+
+func validateClientMetadata(client Client) (OAuth2ErrorCode, string) {
+	if client.TokenEndpointAuthMethod != "" && !slices.Contains(supportedAuthMethods, client.TokenEndpointAuthMethod) {
+		return ErrCodeInvalidClientMetadata, "unsupported token_endpoint_auth_method"
+	}
+	// Keys can only be registered inline, as jwks_uri is not supported.
+	if client.TokenEndpointAuthMethod == AuthMethodPrivateKeyJWT && (client.JWKS == nil || len(client.JWKS.Keys) == 0) {
+		return ErrCodeInvalidClientMetadata, "jwks is required for private_key_jwt"
+	}
+	for _, grantType := range client.GrantTypes {
+		if !slices.Contains(supportedGrantTypes, grantType) {
+			return ErrCodeInvalidClientMetadata, "unsupported grant type " + grantType
+		}
+	}
+	for _, redirectURI := range client.RedirectURIs {
+		if !validRedirectURI(redirectURI) {
+			return ErrCodeInvalidRedirectURI, "invalid redirect uri " + redirectURI
+		}
+	}
+	if slices.Contains(client.GrantTypes, "authorization_code") && len(client.RedirectURIs) == 0 {
+		return ErrCodeInvalidRedirectURI, "redirect_uris are required for the authorization_code grant"
+	}
+	if client.AccessTokenLifetime < 0 || client.RefreshTokenLifetime < 0 {
+		return ErrCodeInvalidClientMetadata, "token lifetimes must not be negative"
+	}
+
+	return "", ""
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, `{"error": "failed to encode response"}`, http.StatusInternalServerError)
+	}
+}
+
+// HandleOAuth2Register implements RFC 7591 dynamic client registration. The
+// server assigns the client ID and, for clients authenticating with a shared
+// secret, the client secret.
+func HandleOAuth2Register(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var client Client
+	if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
+		SendOAuth2Error(w, r, ErrCodeInvalidClientMetadata, "could not decode client metadata")
+		return
+	}
+
+	if client.TokenEndpointAuthMethod == "" {
+		client.TokenEndpointAuthMethod = AuthMethodClientSecretBasic
+	}
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{"authorization_code"}
+	}
+	if errCode, description := validateClientMetadata(client); errCode != "" {
+		SendOAuth2Error(w, r, errCode, description)
+		return
+	}
+
+	client.ClientID = gofakeit.UUID()
+	client.ClientSecret = ""
+	client.ClientSecretPrefix = ""
+	switch client.TokenEndpointAuthMethod {
+	case AuthMethodClientSecretBasic, AuthMethodClientSecretPost, AuthMethodClientSecretJWT:
+		client.ClientSecret = gofakeit.Password(true, true, true, false, false, 32)
+	}
+	client.ClientIDIssuedAt = time.Now().Unix()
+	client.ClientSecretExpiresAt = 0
+
+	if err := RegisterClient(client); err != nil {
+		SendOAuth2Error(w, r, ErrCodeInvalidClientMetadata, err.Error())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, client)
+}
+
+func HandleListClients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ListClients())
+}
+
+func HandleGetClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := LookupClient(mux.Vars(r)["client_id"])
+	if !ok {
+		http.Error(w, `{"error": "client not found"}`, http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, client)
+}
+
+// HandlePutClient creates or replaces the client named in the path with the
+// metadata in the request body. Unlike dynamic registration, the caller
+// chooses the client ID and secret.
+func HandlePutClient(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var client Client
+	if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
+		SendOAuth2Error(w, r, ErrCodeInvalidClientMetadata, "could not decode client metadata")
+		return
+	}
+	client.ClientID = mux.Vars(r)["client_id"]
+
+	if errCode, description := validateClientMetadata(client); errCode != "" {
+		SendOAuth2Error(w, r, errCode, description)
+		return
+	}
+	if client.ClientIDIssuedAt == 0 {
+		client.ClientIDIssuedAt = time.Now().Unix()
+	}
+
+	if err := RegisterClient(client); err != nil {
+		SendOAuth2Error(w, r, ErrCodeInvalidClientMetadata, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, client)
+}
+
+func HandleDeleteClient(w http.ResponseWriter, r *http.Request) {
+	if !DeleteClient(mux.Vars(r)["client_id"]) {
+		http.Error(w, `{"error": "client not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func HandleListUsers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ListUsers())
+}
+
+func HandleGetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := LookupUser(mux.Vars(r)["username"])
+	if !ok {
+		http.Error(w, `{"error": "user not found"}`, http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, user)
+}
+
+func HandlePutUser(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var user User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		http.Error(w, `{"error": "could not decode user"}`, http.StatusBadRequest)
+		return
+	}
+	user.Username = mux.Vars(r)["username"]
+
+	if err := RegisterUser(user); err != nil {
+		http.Error(w, `{"error": "invalid user"}`, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, user)
+}
+
+func HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if !DeleteUser(mux.Vars(r)["username"]) {
+		http.Error(w, `{"error": "user not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDynamicClientRegistration(t *testing.T) {
+	body := `{"client_name": "registered", "token_endpoint_auth_method": "client_secret_post", "grant_types": ["client_credentials"], "scope": "read", "access_token_lifetime": 60}`
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/register", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	HandleOAuth2Register(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("HandleOAuth2Register() status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+
+	var client Client
+	if err := json.Unmarshal(w.Body.Bytes(), &client); err != nil {
+		t.Fatal(err)
+	}
+	if client.ClientID == "" || client.ClientSecret == "" {
+		t.Fatalf("registered client = %+v, want generated credentials", client)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", client.ClientID)
+	form.Set("client_secret", client.ClientSecret)
+	res := requestToken(t, form, nil)
+	if res.ExpiresIn > 60 || res.RefreshToken != "" {
+		t.Errorf("token response = %+v, want 60s lifetime and no refresh token", res)
+	}
+
+	form.Set("grant_type", "password")
+	form.Set("username", "testuser")
+	form.Set("password", "testpassword")
+	req = httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	HandleOAuth2(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), string(ErrCodeUnauthorizedClient)) {
+		t.Errorf("password grant = %v %s, want unauthorized_client", w.Code, w.Body.String())
+	}
+}
+
+func TestDynamicClientRegistrationRejectsInvalidMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode OAuth2ErrorCode
+	}{
+		{
+			name:     "authorization code without redirect uris",
+			body:     `{"grant_types": ["authorization_code"]}`,
+			wantCode: ErrCodeInvalidRedirectURI,
+		},
+		{
+			name:     "relative redirect uri",
+			body:     `{"redirect_uris": ["/callback"]}`,
+			wantCode: ErrCodeInvalidRedirectURI,
+		},
+		{
+			name:     "unknown auth method",
+			body:     `{"token_endpoint_auth_method": "magic", "grant_types": ["client_credentials"]}`,
+			wantCode: ErrCodeInvalidClientMetadata,
+		},
+		{
+			name:     "private_key_jwt without keys",
+			body:     `{"token_endpoint_auth_method": "private_key_jwt", "grant_types": ["client_credentials"]}`,
+			wantCode: ErrCodeInvalidClientMetadata,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/oauth2/register", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			HandleOAuth2Register(w, req)
+
+			var res OAuth2Error
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != http.StatusBadRequest || res.Code != tt.wantCode {
+				t.Errorf("HandleOAuth2Register() = %v %v, want 400 %v", w.Code, res.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRegisteredPublicClientRefresh(t *testing.T) {
+	redirectURI := "https://public.example.com/callback"
+	body := `{"client_name": "public", "token_endpoint_auth_method": "none", "grant_types": ["authorization_code", "refresh_token"], "redirect_uris": ["` + redirectURI + `"]}`
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/register", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	HandleOAuth2Register(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("HandleOAuth2Register() status = %v, want %v: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var client Client
+	if err := json.Unmarshal(w.Body.Bytes(), &client); err != nil {
+		t.Fatal(err)
+	}
+
+	// The redirect_uri is omitted from both requests, so the single
+	// registered one is used.
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", client.ClientID)
+	location := authorize(t, params)
+	if got := location.Scheme + "://" + location.Host + location.Path; got != redirectURI {
+		t.Errorf("redirect = %s, want %s", got, redirectURI)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("client_id", client.ClientID)
+	form.Set("code", location.Query().Get("code"))
+	res := requestToken(t, form, nil)
+	if res.RefreshToken == "" {
+		t.Fatalf("token response is missing refresh_token")
+	}
+
+	form = url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("client_id", client.ClientID)
+	form.Set("refresh_token", res.RefreshToken)
+	requestToken(t, form, nil)
+
+	form.Set("client_secret", "wrong")
+	w = exchangeCode(form)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh with wrong secret status = %v, want %v: %s", w.Code, http.StatusUnauthorized, w.Body.String())
+	}
+}
+
+func TestLoadRegistryRejectsInvalidMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	config := `{"clients": [{"client_id": "config-magic", "token_endpoint_auth_method": "magic"}]}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := LoadRegistry(path)
+	if err == nil || !strings.Contains(err.Error(), "unsupported token_endpoint_auth_method") {
+		t.Errorf("LoadRegistry() error = %v, want unsupported token_endpoint_auth_method", err)
+	}
+	if _, ok := LookupClient("config-magic"); ok {
+		t.Error("invalid client was registered")
+	}
+}
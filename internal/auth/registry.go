@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuthMethodNone = "none"
+
+	defaultAccessTokenLifetime  = time.Hour
+	defaultRefreshTokenLifetime = 60 * 24 * time.Hour
+
+	// defaultUsername is the resource owner that interactive flows (the
+	// authorization and device endpoints) approve requests for when no user
+	// is named.
+	defaultUsername = "testuser"
+)
+
+// Client is a registered OAuth2 client. JSON field names follow the RFC 7591
+// client metadata where one exists.
+type Client struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	// ClientSecretPrefix, when set, accepts any secret starting with it. This
+	// lets test suites isolate their state by using unique secrets.
+	ClientSecretPrefix string `json:"client_secret_prefix,omitempty"`
+	ClientName         string `json:"client_name,omitempty"`
+	// TokenEndpointAuthMethod restricts how the client authenticates. An empty
+	// value accepts every supported method.
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method,omitempty"`
+	// GrantTypes lists the grants the client may use. An empty list allows
+	// every grant.
+	GrantTypes []string `json:"grant_types,omitempty"`
+	// Scope is the space separated list of scopes the client may request. An
+	// empty value allows any scope.
+	Scope string `json:"scope,omitempty"`
+	// RedirectURIs must contain the redirect_uri of authorization requests.
+	// An empty list accepts any absolute URI.
+	RedirectURIs []string `json:"redirect_uris,omitempty"`
+	JWKS         *JWKS    `json:"jwks,omitempty"`
+	// Token lifetimes in seconds; zero uses the server defaults.
+	AccessTokenLifetime  int `json:"access_token_lifetime,omitempty"`
+	RefreshTokenLifetime int `json:"refresh_token_lifetime,omitempty"`
+
+	ClientIDIssuedAt      int64 `json:"client_id_issued_at,omitempty"`
+	ClientSecretExpiresAt int64 `json:"client_secret_expires_at"`
+}
+
+// ValidSecret reports whether secret authenticates the client.
+func (c *Client) ValidSecret(secret string) bool {
+	if secret == "" {
+		return false
+	}
+	if c.ClientSecretPrefix != "" && strings.HasPrefix(secret, c.ClientSecretPrefix) {
+		return true
+	}
+
+	return c.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(c.ClientSecret), []byte(secret)) == 1
+}
+
+// AllowsGrant reports whether the client may use the given grant type.
+func (c *Client) AllowsGrant(grantType string) bool {
+	return len(c.GrantTypes) == 0 || slices.Contains(c.GrantTypes, grantType)
+}
+
+// AllowsAuthMethod reports whether the client may authenticate with method.
+func (c *Client) AllowsAuthMethod(method string) bool {
+	return c.TokenEndpointAuthMethod == "" || c.TokenEndpointAuthMethod == method
+}
+
+// AllowsRedirectURI reports whether redirectURI is registered for the client.
+func (c *Client) AllowsRedirectURI(redirectURI string) bool {
+	return len(c.RedirectURIs) == 0 || slices.Contains(c.RedirectURIs, redirectURI)
+}
+
+func (c *Client) accessTokenLifetime() time.Duration {
+	if c.AccessTokenLifetime > 0 {
+		return time.Duration(c.AccessTokenLifetime) * time.Second
+	}
+
+	return defaultAccessTokenLifetime
+}
+
+func (c *Client) refreshTokenLifetime() time.Duration {
+	if c.RefreshTokenLifetime > 0 {
+		return time.Duration(c.RefreshTokenLifetime) * time.Second
+	}
+
+	return defaultRefreshTokenLifetime
+}
+
+// User is a resource owner known to the authorization server.
+type User struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+}
+
+// RegistryConfig is the format of the file passed to LoadRegistry.
+type RegistryConfig struct {
+	Clients []Client `json:"clients"`
+	Users   []User   `json:"users"`
+}
+
+type registry struct {
+	mu      sync.RWMutex
+	clients map[string]*Client
+	users   map[string]*User
+}
+
+var clientRegistry = newRegistry(RegistryConfig{
+	Clients: []Client{
+		{
+			ClientID:     "beezy",
+			ClientSecret: "super-secret",
+		},
+		{
+			ClientID:           "speakeasy-sdks",
+			ClientSecret:       "supersecret-client-secret-jwt",
+			ClientSecretPrefix: "supersecret-",
+			GrantTypes:         []string{"client_credentials"},
+			Scope:              "read write",
+		},
+	},
+	Users: []User{
+		{
+			Username:      "testuser",
+			Password:      "testpassword",
+			Name:          "Test User",
+			GivenName:     "Test",
+			FamilyName:    "User",
+			Email:         "testuser@example.com",
+			EmailVerified: true,
+		},
+	},
+})
+
+func newRegistry(config RegistryConfig) *registry {
+	reg := &registry{
+		clients: map[string]*Client{},
+		users:   map[string]*User{},
+	}
+	for _, client := range config.Clients {
+		reg.clients[client.ClientID] = &client
+	}
+	for _, user := range config.Users {
+		reg.users[user.Username] = &user
+	}
+
+	return reg
+}
+
+// LoadRegistry adds the clients and users in the JSON file at path to the
+// registry, replacing any existing entries with the same identifiers.
+func LoadRegistry(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var config RegistryConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("invalid registry config %s: %w", path, err)
+	}
+
+	for _, client := range config.Clients {
+		if errCode, description := validateClientMetadata(client); errCode != "" {
+			return fmt.Errorf("client %q: %s", client.ClientID, description)
+		}
+		if err := RegisterClient(client); err != nil {
+			return fmt.Errorf("client %q: %w", client.ClientID, err)
+		}
+	}
+	for _, user := range config.Users {
+		if err := RegisterUser(user); err != nil {
+			return fmt.Errorf("user %q: %w", user.Username, err)
+		}
+	}
+
+	return nil
+}
+
+// LookupClient returns a copy of the registered client.
+func LookupClient(clientID string) (Client, bool) {
+	clientRegistry.mu.RLock()
+	defer clientRegistry.mu.RUnlock()
+
+	client, ok := clientRegistry.clients[clientID]
+	if !ok {
+		return Client{}, false
+	}
+
+	return *client, true
+}
+
+// ListClients returns every registered client ordered by client ID.
+func ListClients() []Client {
+	clientRegistry.mu.RLock()
+	defer clientRegistry.mu.RUnlock()
+
+	clients := make([]Client, 0, len(clientRegistry.clients))
+	for _, client := range clientRegistry.clients {
+		clients = append(clients, *client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ClientID < clients[j].ClientID })
+
+	return clients
+}
+
+// RegisterClient adds or replaces a client. Any JWKS in the client metadata
+// replaces the keys the client signs assertions with.
+func RegisterClient(client Client) error {
+	if client.ClientID == "" {
+		return fmt.Errorf("missing client_id")
+	}
+	if client.JWKS != nil {
+		if err := RegisterClientKeys(client.ClientID, *client.JWKS); err != nil {
+			return err
+		}
+	}
+
+	clientRegistry.mu.Lock()
+	defer clientRegistry.mu.Unlock()
+
+	clientRegistry.clients[client.ClientID] = &client
+	return nil
+}
+
+func DeleteClient(clientID string) bool {
+	clientRegistry.mu.Lock()
+	defer clientRegistry.mu.Unlock()
+
+	if _, ok := clientRegistry.clients[clientID]; !ok {
+		return false
+	}
+	delete(clientRegistry.clients, clientID)
+	clientKeysDB.Delete(clientID)
+
+	return true
+}
+
+func LookupUser(username string) (User, bool) {
+	clientRegistry.mu.RLock()
+	defer clientRegistry.mu.RUnlock()
+
+	user, ok := clientRegistry.users[username]
+	if !ok {
+		return User{}, false
+	}
+
+	return *user, true
+}
+
+func ListUsers() []User {
+	clientRegistry.mu.RLock()
+	defer clientRegistry.mu.RUnlock()
+
+	users := make([]User, 0, len(clientRegistry.users))
+	for _, user := range clientRegistry.users {
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+
+	return users
+}
+
+func RegisterUser(user User) error {
+	if user.Username == "" {
+		return fmt.Errorf("missing username")
+	}
+
+	clientRegistry.mu.Lock()
+	defer clientRegistry.mu.Unlock()
+
+	clientRegistry.users[user.Username] = &user
+	return nil
+}
+
+func DeleteUser(username string) bool {
+	clientRegistry.mu.Lock()
+	defer clientRegistry.mu.Unlock()
+
+	if _, ok := clientRegistry.users[username]; !ok {
+		return false
+	}
+	delete(clientRegistry.users, username)
+
+	return true
+}
+
+// ValidUserCredentials checks resource owner password credentials.
+func ValidUserCredentials(username, password string) bool {
+	user, ok := LookupUser(username)
+	return ok && user.Password != "" && subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) == 1
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+)
+
+const ErrCodeUnsupportedTokenType OAuth2ErrorCode = "unsupported_token_type"
+
+// HandleOAuth2Revoke implements RFC 7009 token revocation. Revoking a refresh
+// token also revokes every access token issued from the same grant. Unknown or
+// invalid tokens are not an error, as required by the RFC.
+func HandleOAuth2Revoke(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if err := r.ParseForm(); err != nil {
+		log.Println(err)
+		SendOAuth2Error(w, r, ErrCodeInvalidRequest, "cannot parse url-encoded request body")
+		return
+	}
+
+	form := TokenForm{
+		ClientID:     requestClientID(r),
+		ClientSecret: r.PostForm.Get("client_secret"),
+	}
+	client, err := authenticateClient(r, form)
+	if err != nil {
+		SendOAuth2Error(w, r, ErrCodeInvalidClient, err.Error())
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		SendOAuth2Error(w, r, ErrCodeInvalidRequest, "missing token")
+		return
+	}
+
+	// Every token lives in the same store, so the token_type_hint is ignored,
+	// including unknown hints (RFC 7009 section 2.1).
+	claims, err := ParseToken(token)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	// Only access and refresh tokens are tracked, not ID tokens.
+	if _, ok := TokenID(claims); !ok {
+		SendOAuth2Error(w, r, ErrCodeUnsupportedTokenType, "token type cannot be revoked")
+		return
+	}
+
+	if claims["clientID"] != client.ClientID {
+		SendOAuth2Error(w, r, ErrCodeUnauthorizedClient, "token was not issued to this client")
+		return
+	}
+
+	if claims["grantType"] == "refresh_token" {
+		RevokeTokenFamily(claims)
+	}
+	RevokeToken(claims)
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+)
+
+const ErrCodeInsufficientScope OAuth2ErrorCode = "insufficient_scope"
+
+// downscope returns the scope to grant for a request of requested when at
+// most granted may be issued. An empty request is granted the full scope.
+func downscope(granted, requested string) (string, bool) {
+	if requested == "" {
+		return granted, true
+	}
+
+	allowed := strings.Fields(granted)
+	for _, s := range strings.Fields(requested) {
+		if !slices.Contains(allowed, s) {
+			return "", false
+		}
+	}
+
+	return strings.Join(strings.Fields(requested), " "), true
+}
+
+// GrantScope checks a requested scope against the scopes the client is
+// registered for. Clients without registered scopes may request any scope.
+func (c *Client) GrantScope(requested string) (string, OAuth2ErrorCode, string) {
+	if c.Scope == "" {
+		return requested, "", ""
+	}
+
+	scope, ok := downscope(c.Scope, requested)
+	if !ok {
+		return "", ErrCodeInvalidScope, "requested scope is not allowed for this client"
+	}
+
+	return scope, "", ""
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestScopeEnforcement(t *testing.T) {
+	if err := RegisterClient(Client{
+		ClientID:     "scoped-client",
+		ClientSecret: "scoped-secret",
+		Scope:        "read write",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", "scoped-client")
+	form.Set("client_secret", "scoped-secret")
+
+	expectInvalidScope := func(form url.Values) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		HandleOAuth2(w, tokenEndpointRequest(form))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), string(ErrCodeInvalidScope)) {
+			t.Errorf("HandleOAuth2() = %v %s, want invalid_scope", w.Code, w.Body.String())
+		}
+	}
+
+	form.Set("scope", "read admin")
+	expectInvalidScope(form)
+
+	form.Del("scope")
+	res := requestToken(t, form, nil)
+	if res.Scope != "read write" {
+		t.Errorf("default scope = %q, want the registered scope", res.Scope)
+	}
+
+	refresh := url.Values{}
+	refresh.Set("grant_type", "refresh_token")
+	refresh.Set("client_id", "scoped-client")
+	refresh.Set("client_secret", "scoped-secret")
+	refresh.Set("refresh_token", res.RefreshToken)
+	refresh.Set("scope", "read")
+	downscoped := requestToken(t, refresh, nil)
+	if downscoped.Scope != "read" || mustParseToken(t, downscoped.AccessToken)["scope"] != "read" {
+		t.Errorf("downscoped token scope = %q, want read", downscoped.Scope)
+	}
+	if scope := mustParseToken(t, downscoped.RefreshToken)["scope"]; scope != "read write" {
+		t.Errorf("refresh token scope = %v, want the original grant", scope)
+	}
+
+	refresh.Set("refresh_token", downscoped.RefreshToken)
+	refresh.Set("scope", "read admin")
+	expectInvalidScope(refresh)
+}
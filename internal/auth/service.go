@@ -2,10 +2,11 @@ package auth
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
 
+	"github.com/gorilla/mux"
 	"github.com/speakeasy-api/speakeasy-api-test-service/internal/utils"
 
 	"github.com/speakeasy-api/speakeasy-api-test-service/pkg/models"
@@ -24,27 +25,25 @@ func HandleAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := checkAuth(req, r); err != nil {
+	if err := checkAuth(req, r, body); err != nil {
+		// Clients only answer a challenge on a 401 response; other failures
+		// keep the status of utils.HandleError.
+		var challenge *challengeError
+		if errors.As(err, &challenge) {
+			w.Header().Set("WWW-Authenticate", challenge.challenge)
+			writeJSON(w, http.StatusUnauthorized, models.ErrorResponse{Error: models.Error{Message: err.Error()}})
+			return
+		}
 		utils.HandleError(w, err)
 		return
 	}
 }
 
+// HandleCustomAuth checks the request against the custom security scheme
+// named in the path. See RegisterCustomSecurityScheme.
 func HandleCustomAuth(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	case "/auth/customsecurity/customSchemeAppId":
-		appID := r.Header.Get("X-Security-App-Id")
-		if appID != "testAppID" {
-			utils.HandleError(w, fmt.Errorf("invalid app id: %w", authError))
-			return
-		}
-		secret := r.Header.Get("X-Security-Secret")
-		if secret != "testSecret" {
-			utils.HandleError(w, fmt.Errorf("invalid secret: %w", authError))
-			return
-		}
-	default:
-		utils.HandleError(w, fmt.Errorf("invalid path"))
+	if err := checkCustomSecurity(mux.Vars(r)["customSchemeType"], r, 0); err != nil {
+		utils.HandleError(w, err)
 		return
 	}
 }
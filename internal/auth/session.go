@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// SessionHeader names the test session a request belongs to. Each
+	// session has its own token state and clock so that parallel test suites
+	// do not affect each other.
+	SessionHeader = "x-oauth2-session"
+
+	// tenantSeparator separates a session prefix from the client ID, e.g.
+	// "suite-a/beezy", for clients that cannot send custom headers.
+	tenantSeparator = "/"
+
+	// defaultSession holds the state of requests without a session.
+	defaultSession = "default"
+)
+
+// TokenSessionTTL is how long a session's token state is kept after its last
+// use. Revoked and rotated tokens are kept until they expire.
+var TokenSessionTTL = 5 * time.Minute
+
+type tokenStore struct {
+	tokens     sync.Map
+	lastAccess atomic.Int64
+}
+
+func (s *tokenStore) touch() {
+	s.lastAccess.Store(time.Now().UnixNano())
+}
+
+var tokenSessions sync.Map
+
+// sessionKey returns the key of a session's state. Requests without a
+// session share the state of the default session.
+func sessionKey(session string) string {
+	if session == "" {
+		return defaultSession
+	}
+
+	return session
+}
+
+// sessionTokens returns the token store of a session, creating it on first
+// use.
+func sessionTokens(session string) *tokenStore {
+	key := sessionKey(session)
+	v, ok := tokenSessions.Load(key)
+	if !ok {
+		v, _ = tokenSessions.LoadOrStore(key, &tokenStore{})
+	}
+	store := v.(*tokenStore)
+	store.touch()
+
+	return store
+}
+
+// splitTenant splits a client ID of the form <session>/<client_id>.
+func splitTenant(clientID string) (string, string) {
+	session, id, ok := strings.Cut(clientID, tenantSeparator)
+	if !ok {
+		return "", clientID
+	}
+
+	return session, id
+}
+
+// requestClientID returns the client_id parameter of a request without its
+// tenant prefix.
+func requestClientID(r *http.Request) string {
+	_, clientID := splitTenant(r.Form.Get("client_id"))
+	return clientID
+}
+
+// RequestSession returns the test session of a request, as requestSession.
+func RequestSession(r *http.Request) string {
+	return requestSession(r)
+}
+
+// requestSession returns the test session of a request from the session
+// header or a tenant prefix of the client ID.
+func requestSession(r *http.Request) string {
+	if session := r.Header.Get(SessionHeader); session != "" {
+		return session
+	}
+
+	clientID := r.Form.Get("client_id")
+	if clientID == "" {
+		clientID, _, _ = r.BasicAuth()
+	}
+	session, _ := splitTenant(clientID)
+
+	return session
+}
+
+// evictTokenSessions drops expired tokens, and the remaining state of
+// sessions that have been idle for longer than TokenSessionTTL. Revoked and
+// rotated tokens are kept until they expire, as they would come back as fresh
+// tokens if forgotten.
+func evictTokenSessions() {
+	idleSince := time.Now().Add(-TokenSessionTTL).UnixNano()
+
+	tokenSessions.Range(func(key, value any) bool {
+		store := value.(*tokenStore)
+		idle := store.lastAccess.Load() < idleSince
+
+		now := clockFor(key.(string)).Now()
+		empty := true
+		store.tokens.Range(func(id, v any) bool {
+			// Forgetting a token is safe when it is re-registered from its
+			// claims in the same state if presented again.
+			rec := v.(tokenRecord)
+			if rec.Expiry.Before(now) || idle && !rec.Revoked && !rec.Rotated {
+				store.tokens.Delete(id)
+			} else {
+				empty = false
+			}
+			return true
+		})
+
+		if idle && empty {
+			tokenSessions.CompareAndDelete(key, store)
+		}
+		return true
+	})
+}
+
+type TokenSession struct {
+	Session    string    `json:"session"`
+	Tokens     int       `json:"tokens"`
+	Revoked    int       `json:"revoked"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+func describeTokenSession(session string, store *tokenStore) TokenSession {
+	res := TokenSession{
+		Session:    session,
+		LastAccess: time.Unix(0, store.lastAccess.Load()),
+	}
+	store.tokens.Range(func(_, rec any) bool {
+		res.Tokens++
+		if rec.(tokenRecord).Revoked {
+			res.Revoked++
+		}
+		return true
+	})
+
+	return res
+}
+
+func HandleListTokenSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := []TokenSession{}
+	tokenSessions.Range(func(key, value any) bool {
+		sessions = append(sessions, describeTokenSession(key.(string), value.(*tokenStore)))
+		return true
+	})
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Session < sessions[j].Session })
+
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func HandleGetTokenSession(w http.ResponseWriter, r *http.Request) {
+	session := sessionKey(mux.Vars(r)["session"])
+	v, ok := tokenSessions.Load(session)
+	if !ok {
+		http.Error(w, `{"error": "session not found"}`, http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, describeTokenSession(session, v.(*tokenStore)))
+}
+
+// HandleResetTokenSession forgets every token of a session, including their
+// revocation and rotation state, and its clock.
+func HandleResetTokenSession(w http.ResponseWriter, r *http.Request) {
+	session := sessionKey(mux.Vars(r)["session"])
+	_, hadTokens := tokenSessions.LoadAndDelete(session)
+	_, hadClock := sessionClocks.LoadAndDelete(session)
+	if !hadTokens && !hadClock {
+		http.Error(w, `{"error": "session not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
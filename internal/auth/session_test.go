@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTokenSessionIsolation(t *testing.T) {
+	form := clientCredentialsForm()
+	form.Set("client_id", "suite-a/beezy")
+	tenant := mustParseToken(t, requestToken(t, form, nil).AccessToken)
+	if tenant["session"] != "suite-a" || tenant["clientID"] != "beezy" {
+		t.Fatalf("tenant token claims = %v, want session suite-a for beezy", tenant)
+	}
+
+	header := mustParseToken(t, requestToken(t, clientCredentialsForm(), map[string]string{SessionHeader: "suite-b"}).AccessToken)
+	if header["session"] != "suite-b" {
+		t.Fatalf("header token session = %v, want suite-b", header["session"])
+	}
+
+	RevokeToken(tenant)
+	if !IsTokenRevoked(tenant) || IsTokenRevoked(header) {
+		t.Fatal("revocation leaked across sessions")
+	}
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/admin/oauth2/sessions/suite-a", nil), map[string]string{"session": "suite-a"})
+	w := httptest.NewRecorder()
+	HandleResetTokenSession(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("HandleResetTokenSession() status = %v", w.Code)
+	}
+	if IsTokenRevoked(tenant) {
+		t.Error("revocation survived a session reset")
+	}
+}
+
+func TestEvictTokenSessions(t *testing.T) {
+	defer func(ttl time.Duration) { TokenSessionTTL = ttl }(TokenSessionTTL)
+
+	requestToken(t, clientCredentialsForm(), map[string]string{SessionHeader: "idle"})
+	TokenSessionTTL = -time.Second
+	evictTokenSessions()
+
+	if _, ok := tokenSessions.Load("idle"); ok {
+		t.Error("idle session was not evicted")
+	}
+}
+
+func TestEvictTokenSessionsKeepsRevokedTokens(t *testing.T) {
+	defer func(ttl time.Duration) { TokenSessionTTL = ttl }(TokenSessionTTL)
+
+	rotation := map[string]string{SessionHeader: "idle-revoked", "x-oauth2-refresh-rotation": "true"}
+	revoked := mustParseToken(t, requestToken(t, clientCredentialsForm(), rotation).AccessToken)
+	RevokeToken(revoked)
+	first := requestToken(t, clientCredentialsForm(), rotation)
+	requestToken(t, refreshForm(first.RefreshToken), rotation)
+
+	TokenSessionTTL = -time.Second
+	evictTokenSessions()
+
+	if !IsTokenRevoked(revoked) {
+		t.Error("revocation was undone by eviction")
+	}
+	if err := RefreshToken(mustParseToken(t, first.RefreshToken)); err == nil {
+		t.Error("rotated refresh token was accepted again after eviction")
+	}
+}
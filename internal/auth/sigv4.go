@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/speakeasy-api/speakeasy-api-test-service/pkg/models"
+)
+
+const (
+	sigV4Algorithm       = "AWS4-HMAC-SHA256"
+	sigV4DateFormat      = "20060102T150405Z"
+	sigV4UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// sigV4MaxSkew is how far the X-Amz-Date of a header signed request may
+	// be from the server time.
+	sigV4MaxSkew = 15 * time.Minute
+	// sigV4MaxExpires is the longest validity of a presigned URL.
+	sigV4MaxExpires = 7 * 24 * time.Hour
+)
+
+// sigV4Keys maps access key IDs to secret access keys. The default key is
+// the one used in the AWS documentation and test suite.
+var sigV4Keys = sync.Map{}
+
+func init() {
+	sigV4Keys.Store("AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+}
+
+func RegisterSigV4Key(accessKeyID, secretAccessKey string) {
+	sigV4Keys.Store(accessKeyID, secretAccessKey)
+}
+
+// SigV4Result describes a verified request, or why verification failed. The
+// canonical request and string to sign are those the server computed, to be
+// compared with the client's.
+type SigV4Result struct {
+	Valid             bool          `json:"valid"`
+	Error             *models.Error `json:"error,omitempty"`
+	Presigned         bool          `json:"presigned"`
+	AccessKeyID       string        `json:"accessKeyId,omitempty"`
+	Region            string        `json:"region,omitempty"`
+	Service           string        `json:"service,omitempty"`
+	SignedHeaders     string        `json:"signedHeaders,omitempty"`
+	PayloadHash       string        `json:"payloadHash,omitempty"`
+	CanonicalRequest  string        `json:"canonicalRequest,omitempty"`
+	StringToSign      string        `json:"stringToSign,omitempty"`
+	Signature         string        `json:"signature,omitempty"`
+	ExpectedSignature string        `json:"expectedSignature,omitempty"`
+}
+
+func (res *SigV4Result) fail(code, format string, args ...any) {
+	res.Valid = false
+	res.Error = &models.Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		Type:    "sigv4",
+	}
+}
+
+// sigV4Params are the signature parameters from either the Authorization
+// header or the presigned query.
+type sigV4Params struct {
+	algorithm     string
+	credential    string
+	signedHeaders string
+	signature     string
+	date          string
+	expires       string
+}
+
+func parseSigV4Authorization(header string) (sigV4Params, bool) {
+	algorithm, rest, ok := strings.Cut(header, " ")
+	if !ok {
+		return sigV4Params{}, false
+	}
+
+	params := sigV4Params{algorithm: algorithm}
+	for _, part := range strings.Split(rest, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "Credential":
+			params.credential = value
+		case "SignedHeaders":
+			params.signedHeaders = value
+		case "Signature":
+			params.signature = value
+		}
+	}
+
+	return params, params.credential != "" && params.signedHeaders != "" && params.signature != ""
+}
+
+// sigV4Encode is the URI encoding of the SigV4 canonical request: every byte
+// except the RFC 3986 unreserved characters is percent encoded.
+func sigV4Encode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func canonicalURI(u *url.URL) string {
+	path := u.Path
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = sigV4Encode(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery sorts the encoded parameters by key and then by value.
+// Sorting the joined pairs instead would misorder keys that prefix others,
+// such as a and a.b, as '.' sorts before '='.
+func canonicalQuery(query url.Values) string {
+	var pairs [][2]string
+	for key, values := range query {
+		if key == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, [2]string{sigV4Encode(key), sigV4Encode(value)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, pair := range pairs {
+		encoded[i] = pair[0] + "=" + pair[1]
+	}
+
+	return strings.Join(encoded, "&")
+}
+
+func canonicalHeaders(r *http.Request, signedHeaders []string) string {
+	var b strings.Builder
+	for _, name := range signedHeaders {
+		values := r.Header.Values(name)
+		if name == "host" {
+			values = []string{r.Host}
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		b.WriteString(name + ":" + strings.Join(trimmed, ",") + "\n")
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifySigV4 checks the AWS Signature Version 4 of a request signed either
+// with the Authorization header or as a presigned URL.
+func verifySigV4(r *http.Request, body []byte, now time.Time) SigV4Result {
+	var res SigV4Result
+	query := r.URL.Query()
+
+	var params sigV4Params
+	if query.Get("X-Amz-Signature") != "" {
+		res.Presigned = true
+		params = sigV4Params{
+			algorithm:     query.Get("X-Amz-Algorithm"),
+			credential:    query.Get("X-Amz-Credential"),
+			signedHeaders: query.Get("X-Amz-SignedHeaders"),
+			signature:     query.Get("X-Amz-Signature"),
+			date:          query.Get("X-Amz-Date"),
+			expires:       query.Get("X-Amz-Expires"),
+		}
+	} else {
+		var ok bool
+		params, ok = parseSigV4Authorization(r.Header.Get("Authorization"))
+		if !ok {
+			res.fail("IncompleteSignature", "missing or malformed SigV4 Authorization header")
+			return res
+		}
+		params.date = r.Header.Get("X-Amz-Date")
+	}
+
+	if params.algorithm != sigV4Algorithm {
+		res.fail("IncompleteSignature", "unsupported algorithm %q, expected %s", params.algorithm, sigV4Algorithm)
+		return res
+	}
+
+	// Credential is <access key>/<date>/<region>/<service>/aws4_request.
+	scopeParts := strings.Split(params.credential, "/")
+	if len(scopeParts) != 5 || scopeParts[4] != "aws4_request" {
+		res.fail("IncompleteSignature", "malformed credential %q", params.credential)
+		return res
+	}
+	res.AccessKeyID, res.Region, res.Service = scopeParts[0], scopeParts[2], scopeParts[3]
+	res.SignedHeaders = params.signedHeaders
+	res.Signature = params.signature
+
+	secret, ok := sigV4Keys.Load(res.AccessKeyID)
+	if !ok {
+		res.fail("InvalidClientTokenId", "unknown access key %q", res.AccessKeyID)
+		return res
+	}
+
+	signedAt, err := time.Parse(sigV4DateFormat, params.date)
+	if err != nil {
+		res.fail("IncompleteSignature", "missing or malformed X-Amz-Date %q", params.date)
+		return res
+	}
+	if signedAt.Format("20060102") != scopeParts[1] {
+		res.fail("SignatureDoesNotMatch", "credential date %s does not match X-Amz-Date %s", scopeParts[1], params.date)
+		return res
+	}
+	if res.Presigned {
+		seconds, err := strconv.Atoi(params.expires)
+		expires := time.Duration(seconds) * time.Second
+		if err != nil || seconds <= 0 || expires > sigV4MaxExpires {
+			res.fail("AuthorizationQueryParametersError", "X-Amz-Expires must be between 1 and %d seconds", int(sigV4MaxExpires.Seconds()))
+			return res
+		}
+		if now.After(signedAt.Add(expires)) {
+			res.fail("RequestExpired", "presigned request expired at %s", signedAt.Add(expires).Format(time.RFC3339))
+			return res
+		}
+	} else if d := now.Sub(signedAt); d > sigV4MaxSkew || d < -sigV4MaxSkew {
+		res.fail("RequestTimeTooSkewed", "X-Amz-Date %s is more than %s from the server time %s", params.date, sigV4MaxSkew, now.UTC().Format(sigV4DateFormat))
+		return res
+	}
+
+	signedHeaders := strings.Split(params.signedHeaders, ";")
+	if !sort.StringsAreSorted(signedHeaders) {
+		res.fail("SignatureDoesNotMatch", "SignedHeaders must be sorted")
+		return res
+	}
+	for _, name := range signedHeaders {
+		if name != strings.ToLower(name) {
+			res.fail("SignatureDoesNotMatch", "SignedHeaders must be lowercase")
+			return res
+		}
+	}
+	if !strings.Contains(";"+params.signedHeaders+";", ";host;") {
+		res.fail("SignatureDoesNotMatch", "the host header must be signed")
+		return res
+	}
+
+	// Presigned URLs default to an unsigned payload as the body is not
+	// known when the URL is created.
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	switch {
+	case payloadHash == sigV4UnsignedPayload:
+	case payloadHash != "":
+		if actual := sha256Hex(body); payloadHash != actual {
+			res.fail("XAmzContentSHA256Mismatch", "X-Amz-Content-Sha256 %s does not match the body hash %s", payloadHash, actual)
+			return res
+		}
+	case res.Presigned:
+		payloadHash = sigV4UnsignedPayload
+	default:
+		payloadHash = sha256Hex(body)
+	}
+	res.PayloadHash = payloadHash
+
+	res.CanonicalRequest = strings.Join([]string{
+		r.Method,
+		canonicalURI(r.URL),
+		canonicalQuery(query),
+		canonicalHeaders(r, signedHeaders),
+		params.signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	res.StringToSign = strings.Join([]string{
+		sigV4Algorithm,
+		params.date,
+		strings.Join(scopeParts[1:], "/"),
+		sha256Hex([]byte(res.CanonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secret.(string)), scopeParts[1])
+	key = hmacSHA256(key, res.Region)
+	key = hmacSHA256(key, res.Service)
+	key = hmacSHA256(key, "aws4_request")
+	res.ExpectedSignature = hex.EncodeToString(hmacSHA256(key, res.StringToSign))
+
+	if !hmac.Equal([]byte(res.ExpectedSignature), []byte(params.signature)) {
+		res.fail("SignatureDoesNotMatch", "the request signature does not match the signature calculated by the server; compare the canonicalRequest and stringToSign")
+		return res
+	}
+
+	res.Valid = true
+	return res
+}
+
+// HandleSigV4Auth verifies requests signed with AWS Signature Version 4 and
+// responds with the verification diagnostics.
+func HandleSigV4Auth(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, `{"error": "failed to read request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	res := verifySigV4(r, body, time.Now())
+
+	status := http.StatusOK
+	if !res.Valid {
+		status = http.StatusForbidden
+	}
+	writeJSON(w, status, res)
+}
+
+// HandlePutSigV4Key registers the secret access key of the access key ID in
+// the path.
+func HandlePutSigV4Key(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var req struct {
+		SecretAccessKey string `json:"secretAccessKey"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SecretAccessKey == "" {
+		http.Error(w, `{"error": "secretAccessKey is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	RegisterSigV4Key(mux.Vars(r)["access_key_id"], req.SecretAccessKey)
+	w.WriteHeader(http.StatusNoContent)
+}
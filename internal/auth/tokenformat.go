@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	contentTypeJSON = "application/json"
+	contentTypeForm = "application/x-www-form-urlencoded"
+)
+
+// ParseTokenRequest parses the parameters of a token request into r.Form and
+// r.PostForm. Besides url-encoded bodies, JSON objects are accepted as sent
+// to some providers: strings, numbers and booleans become single values,
+// arrays become repeated values and a scope array is joined with spaces.
+func ParseTokenRequest(r *http.Request) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != contentTypeJSON {
+		return r.ParseForm()
+	}
+
+	// Only the query is parsed for JSON content types.
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var body map[string]any
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return fmt.Errorf("invalid JSON body: %w", err)
+	}
+
+	r.PostForm = url.Values{}
+	for name, value := range body {
+		values, err := jsonFormValues(value)
+		if err != nil {
+			return fmt.Errorf("parameter %s: %w", name, err)
+		}
+		if name == "scope" && len(values) > 1 {
+			values = []string{strings.Join(values, " ")}
+		}
+		r.PostForm[name] = values
+	}
+
+	// Body values take precedence over the query, as with ParseForm.
+	for name, values := range r.PostForm {
+		r.Form[name] = append(values, r.Form[name]...)
+	}
+
+	return nil
+}
+
+func jsonFormValues(value any) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case json.Number:
+		return []string{v.String()}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case []any:
+		var values []string
+		for _, item := range v {
+			if _, nested := item.([]any); nested {
+				return nil, errors.New("nested arrays are not supported")
+			}
+			itemValues, err := jsonFormValues(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, itemValues...)
+		}
+		return values, nil
+	default:
+		return nil, errors.New("objects are not supported")
+	}
+}
+
+// tokenResponseFormat picks the media type of a token endpoint response. JSON
+// is used unless the client only accepts url-encoded responses, as GitHub
+// returns by default. The x-oauth2-response-format header (json or form)
+// forces a format regardless of Accept.
+func tokenResponseFormat(r *http.Request) string {
+	switch r.Header.Get("x-oauth2-response-format") {
+	case "json":
+		return contentTypeJSON
+	case "form":
+		return contentTypeForm
+	}
+
+	accept := strings.Join(r.Header.Values("Accept"), ",")
+	if strings.Contains(accept, contentTypeForm) && !strings.Contains(accept, contentTypeJSON) {
+		return contentTypeForm
+	}
+
+	return contentTypeJSON
+}
+
+// WriteTokenResponse writes a token endpoint response in the format
+// negotiated with the client. Responses are never cached.
+func WriteTokenResponse(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if tokenResponseFormat(r) == contentTypeJSON {
+		writeJSON(w, status, v)
+		return
+	}
+
+	values, err := formValues(v)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, `{"error": "failed to encode response"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeForm)
+	w.WriteHeader(status)
+	if _, err := w.Write([]byte(values.Encode())); err != nil {
+		log.Println(err)
+	}
+}
+
+// formValues converts v to url-encoded values through its JSON encoding so
+// that field names and omitempty rules match the JSON response.
+func formValues(v any) (url.Values, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]any
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	values := url.Values{}
+	for name, value := range fields {
+		fieldValues, err := jsonFormValues(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", name, err)
+		}
+		values[name] = fieldValues
+	}
+
+	return values, nil
+}
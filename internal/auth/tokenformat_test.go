@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseTokenRequestJSON(t *testing.T) {
+	body := `{"grant_type": "client_credentials", "scope": ["read", "write"], "audience": ["a", "b"], "expires": 60, "extra": null}`
+	req := httptest.NewRequest(http.MethodPost, "/token?client_id=query", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	if err := ParseTokenRequest(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := req.PostForm.Get("grant_type"); got != "client_credentials" {
+		t.Errorf("grant_type = %q", got)
+	}
+	if got := req.PostForm.Get("scope"); got != "read write" {
+		t.Errorf("scope = %q, want %q", got, "read write")
+	}
+	if got := req.PostForm["audience"]; len(got) != 2 {
+		t.Errorf("audience = %v, want two values", got)
+	}
+	if got := req.PostForm.Get("expires"); got != "60" {
+		t.Errorf("expires = %q, want %q", got, "60")
+	}
+	if got := req.Form.Get("client_id"); got != "query" {
+		t.Errorf("client_id = %q, want the query value", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(`{"scope": {"read": true}}`))
+	req.Header.Set("Content-Type", "application/json")
+	if err := ParseTokenRequest(req); err == nil {
+		t.Errorf("expected an error for object values")
+	}
+}
+
+func TestWriteTokenResponse(t *testing.T) {
+	res := OAuth2TokenResponse{AccessToken: "token", TokenType: "Bearer", ExpiresIn: 60}
+
+	tests := []struct {
+		name            string
+		header          http.Header
+		wantContentType string
+	}{
+		{name: "default", header: http.Header{}, wantContentType: contentTypeJSON},
+		{name: "accept form", header: http.Header{"Accept": {contentTypeForm}}, wantContentType: contentTypeForm},
+		{name: "accept both", header: http.Header{"Accept": {contentTypeForm + ", " + contentTypeJSON}}, wantContentType: contentTypeJSON},
+		{name: "forced form", header: http.Header{"X-Oauth2-Response-Format": {"form"}, "Accept": {"*/*"}}, wantContentType: contentTypeForm},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/token", nil)
+			req.Header = tt.header
+			w := httptest.NewRecorder()
+			WriteTokenResponse(w, req, http.StatusOK, res)
+
+			if got := w.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Fatalf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if tt.wantContentType != contentTypeForm {
+				return
+			}
+
+			values, err := url.ParseQuery(w.Body.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if values.Get("access_token") != "token" || values.Get("expires_in") != "60" || values.Has("refresh_token") {
+				t.Errorf("unexpected form response %q", w.Body.String())
+			}
+		})
+	}
+}
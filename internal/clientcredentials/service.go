@@ -1,20 +1,34 @@
 package clientcredentials
 
 import (
+	"context"
 	"encoding/base64"
-	"encoding/json"
 	"net/http"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/speakeasy-api/speakeasy-api-test-service/internal/auth"
 )
 
-var state = sync.Map{}
+// tokens maps issued access tokens to their issuedToken.
+var tokens = sync.Map{}
 
-const (
-	firstAccessToken  = "super-duper-access-token"
-	secondAccessToken = "second-super-duper-access-token"
-)
+// forced maps forcedKey values to the number of upcoming authenticated
+// requests that are rejected regardless of the token. The empty key applies
+// to every caller.
+var forced = sync.Map{}
+
+// DefaultExpiresIn is the lifetime in seconds of tokens requested without
+// the expires_in query parameter.
+var DefaultExpiresIn = 3600
+
+// accessTokenPrefix starts every issued access token.
+const accessTokenPrefix = "super-duper-access-token"
 
 func handleBasicAuth(authHeader string) (clientID, clientSecret string, ok bool) {
 	// Remove "Basic " prefix (case-insensitive)
@@ -38,15 +52,18 @@ func handleBasicAuth(authHeader string) (clientID, clientSecret string, ok bool)
 	return creds[0], creds[1], true
 }
 
-
 func HandleTokenRequest(w http.ResponseWriter, r *http.Request) {
 	var clientID, clientSecret string
-	err := r.ParseForm()
+	err := auth.ParseTokenRequest(r)
 	if err != nil {
 		http.Error(w, "invalid_request", http.StatusBadRequest)
 		return
 	}
 
+	// authenticated is set once a client assertion or certificate has
+	// authenticated the client, which then has no secret to check.
+	var authenticated bool
+
 	// Check for Basic Auth header
 	if authHeader := r.Header.Get("Authorization"); strings.HasPrefix(strings.ToLower(authHeader), "basic ") {
 		var ok bool
@@ -55,6 +72,23 @@ func HandleTokenRequest(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "invalid_client", http.StatusUnauthorized)
 			return
 		}
+	} else if r.Form.Get("client_assertion") != "" || r.Form.Get("client_assertion_type") != "" {
+		// private_key_jwt keys and client_secret_jwt secrets come from the
+		// auth client registry.
+		var err error
+		clientID, _, err = auth.VerifyClientAssertion(r, auth.RegisteredClientSecret)
+		if err != nil {
+			http.Error(w, "invalid_client", http.StatusUnauthorized)
+			return
+		}
+		authenticated = true
+	} else if auth.HasTLSClientCertificate(r) && r.Form.Get("client_secret") == "" {
+		clientID = r.Form.Get("client_id")
+		if err := auth.VerifyTLSClientCertificate(r, clientID); err != nil {
+			http.Error(w, "invalid_client", http.StatusUnauthorized)
+			return
+		}
+		authenticated = true
 	} else {
 		clientID = r.Form.Get("client_id")
 		clientSecret = r.Form.Get("client_secret")
@@ -65,62 +99,163 @@ func HandleTokenRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-
-
-	if clientID == "" || clientSecret == "" {
+	if !authenticated && (clientID == "" || clientSecret == "") {
 		http.Error(w, "invalid_request", http.StatusBadRequest)
 		return
 	}
-	if clientID != "speakeasy-sdks" || !strings.HasPrefix(clientSecret, "supersecret-") {
+
+	// Clients, their secrets and keys are managed through the auth client
+	// registry.
+	client, ok := auth.LookupClient(clientID)
+	if !ok || !client.AllowsGrant("client_credentials") {
 		http.Error(w, "invalid_client", http.StatusUnauthorized)
 		return
 	}
-
-	scopes := strings.Split(r.Form.Get("scope"), " ")
-	if len(scopes) == 0 {
-		http.Error(w, "invalid_scope", http.StatusBadRequest)
+	if !authenticated && !client.ValidSecret(clientSecret) {
+		http.Error(w, "invalid_client", http.StatusUnauthorized)
 		return
 	}
 
-	if !slices.Contains(scopes, "read") && !slices.Contains(scopes, "write") {
-		http.Error(w, "invalid_scope", http.StatusBadRequest)
+	scope, errCode, _ := client.GrantScope(r.Form.Get("scope"))
+	if errCode != "" {
+		http.Error(w, string(errCode), http.StatusBadRequest)
 		return
 	}
+	scopes := strings.Fields(scope)
 
 	tokenType := r.URL.Query().Get("token_type")
 	if tokenType == "" {
 		tokenType = "Bearer" // default
 	}
 
-	accessToken := firstAccessToken
-
-	_, ok := state.Load(clientSecret)
-	if !ok {
-		state.Store(clientSecret, true)
-	} else {
-		accessToken = secondAccessToken
+	expiresIn := DefaultExpiresIn
+	if v := r.URL.Query().Get("expires_in"); v != "" {
+		expiresIn, err = strconv.Atoi(v)
+		if err != nil || expiresIn < 0 {
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	// Every request gets a new token bound to the requested scopes, so
+	// callers caching tokens per scope set can be told apart.
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+	accessToken := issueToken(auth.RequestSession(r), clientID, clientSecret, scopes, time.Duration(expiresIn)*time.Second)
 
 	type tokenResponse struct {
 		AccessToken string `json:"access_token"`
 		TokenType   string `json:"token_type"`
 		ExpiresIn   int    `json:"expires_in"`
+		Scope       string `json:"scope"`
 	}
 
 	response := tokenResponse{
 		AccessToken: accessToken,
 		TokenType:   tokenType,
-		ExpiresIn:   0,
+		ExpiresIn:   expiresIn,
+		Scope:       strings.Join(scopes, " "),
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "server_error", http.StatusInternalServerError)
-		return
+	auth.WriteTokenResponse(w, r, http.StatusOK, response)
+}
+
+type issuedToken struct {
+	clientID     string
+	clientSecret string // empty for clients authenticated by assertion or certificate
+	scopes       []string
+	session      string
+	expiresAt    time.Time // zero when the token does not expire
+}
+
+func (t issuedToken) expired() bool {
+	return !t.expiresAt.IsZero() && !auth.SessionClock(t.session).Now().Before(t.expiresAt)
+}
+
+// issueToken stores a new access token expiring on the clock of the OAuth2
+// test session it was requested in. Expired tokens are dropped when presented
+// or by StartTokenCompaction.
+func issueToken(session, clientID, clientSecret string, scopes []string, lifetime time.Duration) string {
+	accessToken := accessTokenPrefix + "-" + gofakeit.UUID()
+
+	token := issuedToken{clientID: clientID, clientSecret: clientSecret, scopes: scopes, session: session}
+	if lifetime > 0 {
+		token.expiresAt = auth.SessionClock(session).Now().Add(lifetime)
 	}
+	tokens.Store(accessToken, token)
+
+	return accessToken
+}
+
+func purgeExpiredTokens() {
+	tokens.Range(func(key, value any) bool {
+		if value.(issuedToken).expired() {
+			tokens.Delete(key)
+		}
+		return true
+	})
 }
 
+// StartTokenCompaction periodically drops expired access tokens until ctx is
+// done.
+func StartTokenCompaction(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purgeExpiredTokens()
+		}
+	}
+}
+
+// forcedKey is the key of the forced failures of a client, or of the tokens
+// issued for one secret. Secrets are keyed apart so that each test can use a
+// unique suffix of the client's secret prefix.
+func forcedKey(clientID, clientSecret string) string {
+	if clientSecret != "" {
+		return "client_secret:" + clientSecret
+	}
+	if clientID != "" {
+		return "client_id:" + clientID
+	}
+
+	return ""
+}
+
+// takeForced consumes one forced failure of the token's secret, its client or
+// every caller.
+func takeForced(token issuedToken) bool {
+	keys := []string{forcedKey(token.clientID, ""), ""}
+	if token.clientSecret != "" {
+		keys = slices.Insert(keys, 0, forcedKey("", token.clientSecret))
+	}
+
+	for _, key := range keys {
+		v, ok := forced.Load(key)
+		if !ok {
+			continue
+		}
+		if v.(*atomic.Int64).Add(-1) >= 0 {
+			return true
+		}
+		forced.CompareAndDelete(key, v)
+	}
+
+	return false
+}
+
+func sendInvalidToken(w http.ResponseWriter, description string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description=`+auth.QuoteChallengeParam(description))
+	http.Error(w, "invalid_token", http.StatusUnauthorized)
+}
+
+// HandleAuthenticatedRequest accepts requests with an unexpired access token.
+// The optional scope query parameter lists scopes the token must have been
+// issued for.
 func HandleAuthenticatedRequest(w http.ResponseWriter, r *http.Request) {
 	accessToken := r.Header.Get("Authorization")
 	if accessToken == "" {
@@ -129,10 +264,61 @@ func HandleAuthenticatedRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	accessToken = strings.TrimPrefix(accessToken, "Bearer ")
-	if accessToken != firstAccessToken && accessToken != secondAccessToken {
-		http.Error(w, "invalid_token", http.StatusUnauthorized)
+	v, ok := tokens.Load(accessToken)
+	if !ok {
+		sendInvalidToken(w, "unknown access token")
 		return
 	}
+	token := v.(issuedToken)
+
+	if token.expired() {
+		tokens.Delete(accessToken)
+		sendInvalidToken(w, "access token expired")
+		return
+	}
+
+	// A forced failure revokes the token, as a server would after a key
+	// rotation, so that the client has to fetch a new one.
+	if takeForced(token) {
+		tokens.Delete(accessToken)
+		sendInvalidToken(w, "access token revoked")
+		return
+	}
+
+	for _, scope := range strings.Fields(r.URL.Query().Get("scope")) {
+		if !slices.Contains(token.scopes, scope) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope=`+auth.QuoteChallengeParam(r.URL.Query().Get("scope")))
+			http.Error(w, "insufficient_scope", http.StatusForbidden)
+			return
+		}
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// HandleForceUnauthorized makes the next count (default 1) authenticated
+// requests fail with 401 invalid_token. The client_id query parameter limits
+// this to tokens issued to that client, and client_secret to tokens issued
+// for that secret; without either every caller is affected.
+func HandleForceUnauthorized(w http.ResponseWriter, r *http.Request) {
+	count := 1
+	if v := r.URL.Query().Get("count"); v != "" {
+		var err error
+		count, err = strconv.Atoi(v)
+		if err != nil || count < 0 {
+			http.Error(w, `{"error": "count must be a non-negative integer"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	key := forcedKey(r.URL.Query().Get("client_id"), r.URL.Query().Get("client_secret"))
+	if count == 0 {
+		forced.Delete(key)
+	} else {
+		n := &atomic.Int64{}
+		n.Store(int64(count))
+		forced.Store(key, n)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,19 +2,25 @@ package clientcredentials
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/speakeasy-api/speakeasy-api-test-service/internal/auth"
 )
 
 func TestHandleTokenRequest(t *testing.T) {
 	tests := []struct {
-		name           string
-		setupRequest   func() *http.Request
-		wantStatus     int
+		name            string
+		setupRequest    func() *http.Request
+		wantStatus      int
 		wantAccessToken string
 	}{
 		{
@@ -30,8 +36,8 @@ func TestHandleTokenRequest(t *testing.T) {
 				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 				return req
 			},
-			wantStatus:     http.StatusOK,
-			wantAccessToken: firstAccessToken,
+			wantStatus:      http.StatusOK,
+			wantAccessToken: accessTokenPrefix,
 		},
 		{
 			name: "valid basic auth",
@@ -49,8 +55,20 @@ func TestHandleTokenRequest(t *testing.T) {
 
 				return req
 			},
-			wantStatus:     http.StatusOK,
-			wantAccessToken: firstAccessToken,
+			wantStatus:      http.StatusOK,
+			wantAccessToken: accessTokenPrefix,
+		},
+		{
+			name: "valid json credentials",
+			setupRequest: func() *http.Request {
+				body := `{"grant_type": "client_credentials", "client_id": "speakeasy-sdks", "client_secret": "supersecret-json", "scope": ["read", "write"]}`
+
+				req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+			wantStatus:      http.StatusOK,
+			wantAccessToken: accessTokenPrefix,
 		},
 		{
 			name: "invalid basic auth format",
@@ -103,6 +121,32 @@ func TestHandleTokenRequest(t *testing.T) {
 			},
 			wantStatus: http.StatusBadRequest,
 		},
+		{
+			name: "valid client_secret_jwt assertion",
+			setupRequest: func() *http.Request {
+				assertion, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+					"iss": "speakeasy-sdks",
+					"sub": "speakeasy-sdks",
+					"aud": "http://example.com/token",
+					"exp": time.Now().Add(time.Minute).Unix(),
+				}).SignedString([]byte("supersecret-client-secret-jwt"))
+				if err != nil {
+					panic(err)
+				}
+
+				form := url.Values{}
+				form.Set("grant_type", "client_credentials")
+				form.Set("scope", "read write")
+				form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+				form.Set("client_assertion", assertion)
+
+				req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			wantStatus:      http.StatusOK,
+			wantAccessToken: accessTokenPrefix,
+		},
 		{
 			name: "case insensitive basic prefix",
 			setupRequest: func() *http.Request {
@@ -118,8 +162,8 @@ func TestHandleTokenRequest(t *testing.T) {
 
 				return req
 			},
-			wantStatus:     http.StatusOK,
-			wantAccessToken: firstAccessToken,
+			wantStatus:      http.StatusOK,
+			wantAccessToken: accessTokenPrefix,
 		},
 	}
 
@@ -139,4 +183,168 @@ func TestHandleTokenRequest(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+// lifecycleSession is the test session whose clock TestTokenLifecycle
+// controls.
+const lifecycleSession = "clientcredentials-lifecycle"
+
+func requestToken(t *testing.T, secret, scope, query string) map[string]any {
+	t.Helper()
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", "speakeasy-sdks")
+	form.Set("client_secret", secret)
+	form.Set("scope", scope)
+
+	req := httptest.NewRequest(http.MethodPost, "/token?"+query, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(auth.SessionHeader, lifecycleSession)
+	w := httptest.NewRecorder()
+	HandleTokenRequest(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandleTokenRequest() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	var res map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	return res
+}
+
+func authenticatedRequest(token, scope string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/authenticatedrequest?scope="+url.QueryEscape(scope), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	HandleAuthenticatedRequest(w, req)
+	return w
+}
+
+func TestTokenLifecycle(t *testing.T) {
+	first := requestToken(t, "supersecret-lifecycle", "read write", "expires_in=1")
+	second := requestToken(t, "supersecret-lifecycle", "read", "")
+
+	if first["access_token"] == second["access_token"] {
+		t.Errorf("tokens are not unique")
+	}
+	if first["expires_in"] != float64(1) || second["expires_in"] != float64(DefaultExpiresIn) {
+		t.Errorf("expires_in = %v, %v", first["expires_in"], second["expires_in"])
+	}
+
+	if w := authenticatedRequest(first["access_token"].(string), "write"); w.Code != http.StatusOK {
+		t.Errorf("scoped request status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if w := authenticatedRequest(second["access_token"].(string), "write"); w.Code != http.StatusForbidden {
+		t.Errorf("out of scope request status = %v, want %v", w.Code, http.StatusForbidden)
+	}
+
+	HandleForceUnauthorized(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/force401?client_secret=supersecret-lifecycle", nil))
+	w := authenticatedRequest(second["access_token"].(string), "")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Errorf("forced request status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+	if w := authenticatedRequest(second["access_token"].(string), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/oauth2/clock/"+lifecycleSession+"/advance?seconds=1", nil)
+	req = mux.SetURLVars(req, map[string]string{"session": lifecycleSession, "action": "advance"})
+	auth.HandleUpdateClock(httptest.NewRecorder(), req)
+	if w := authenticatedRequest(first["access_token"].(string), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired token status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestForceUnauthorizedClientID(t *testing.T) {
+	// Tokens of clients authenticated by assertion or certificate have no
+	// secret and are forced by their client ID.
+	forcedToken := issueToken("", "clientcredentials-forced", "", nil, 0)
+	otherToken := issueToken("", "speakeasy-sdks", "", nil, 0)
+	defer tokens.Delete(otherToken)
+
+	HandleForceUnauthorized(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/force401?client_id=clientcredentials-forced", nil))
+
+	if w := authenticatedRequest(otherToken, ""); w.Code != http.StatusOK {
+		t.Errorf("other client status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if w := authenticatedRequest(forcedToken, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("forced client status = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestPurgeExpiredTokens(t *testing.T) {
+	const session = "clientcredentials-purge"
+	defer func() {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/admin/oauth2/clock/"+session, nil), map[string]string{"session": session})
+		auth.HandleResetClock(httptest.NewRecorder(), req)
+	}()
+
+	expiring := issueToken(session, "speakeasy-sdks", "", nil, time.Second)
+	lasting := issueToken(session, "speakeasy-sdks", "", nil, 0)
+	defer tokens.Delete(lasting)
+
+	// Tokens are kept until they expire on their session clock.
+	purgeExpiredTokens()
+	if _, ok := tokens.Load(expiring); !ok {
+		t.Fatal("unexpired token was purged")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/oauth2/clock/"+session+"/advance?seconds=1", nil)
+	req = mux.SetURLVars(req, map[string]string{"session": session, "action": "advance"})
+	auth.HandleUpdateClock(httptest.NewRecorder(), req)
+
+	purgeExpiredTokens()
+	if _, ok := tokens.Load(expiring); ok {
+		t.Error("expired token was not purged")
+	}
+	if _, ok := tokens.Load(lasting); !ok {
+		t.Error("token without a lifetime was purged")
+	}
+}
+
+func TestRegisteredClient(t *testing.T) {
+	if err := auth.RegisterClient(auth.Client{ClientID: "clientcredentials-registered", ClientSecret: "registered-secret", GrantTypes: []string{"client_credentials"}, Scope: "reports:read reports:write"}); err != nil {
+		t.Fatal(err)
+	}
+	defer auth.DeleteClient("clientcredentials-registered")
+	if err := auth.RegisterClient(auth.Client{ClientID: "clientcredentials-no-grant", ClientSecret: "registered-secret", GrantTypes: []string{"password"}}); err != nil {
+		t.Fatal(err)
+	}
+	defer auth.DeleteClient("clientcredentials-no-grant")
+
+	tests := []struct {
+		clientID   string
+		secret     string
+		scope      string
+		wantStatus int
+	}{
+		{clientID: "clientcredentials-registered", secret: "registered-secret", scope: "reports:read", wantStatus: http.StatusOK},
+		{clientID: "clientcredentials-registered", secret: "registered-secret", scope: "read", wantStatus: http.StatusBadRequest},
+		{clientID: "clientcredentials-registered", secret: "wrong-secret", scope: "reports:read", wantStatus: http.StatusUnauthorized},
+		{clientID: "clientcredentials-no-grant", secret: "registered-secret", scope: "read", wantStatus: http.StatusUnauthorized},
+		{clientID: "clientcredentials-unknown", secret: "registered-secret", scope: "read", wantStatus: http.StatusUnauthorized},
+		{clientID: "speakeasy-sdks", secret: "supersecret-123", scope: "read admin", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.clientID+"/"+tt.secret+"/"+tt.scope, func(t *testing.T) {
+			form := url.Values{}
+			form.Set("grant_type", "client_credentials")
+			form.Set("client_id", tt.clientID)
+			form.Set("client_secret", tt.secret)
+			form.Set("scope", tt.scope)
+
+			req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			HandleTokenRequest(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("HandleTokenRequest() status = %v, want %v: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package cookiesession
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/speakeasy-api/speakeasy-api-test-service/internal/middleware"
+)
+
+func HandleGetSession(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	s, ok := middleware.CookieSessionFromContext(r)
+	if !ok {
+		http.Error(rw, `{"error": "no session"}`, http.StatusUnauthorized)
+		return
+	}
+
+	enc := json.NewEncoder(rw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		http.Error(rw, `{"error": "could not encode response"}`, http.StatusInternalServerError)
+	}
+}
+
+// HandleUpdateSession merges the JSON object of the request body into the
+// session data.
+func HandleUpdateSession(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	s, ok := middleware.CookieSessionFromContext(r)
+	if !ok {
+		http.Error(rw, `{"error": "no session"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var values map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		http.Error(rw, `{"error": "request body must be a JSON object"}`, http.StatusBadRequest)
+		return
+	}
+	s.Update(values)
+
+	enc := json.NewEncoder(rw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		http.Error(rw, `{"error": "could not encode response"}`, http.StatusInternalServerError)
+	}
+}
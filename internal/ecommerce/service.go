@@ -20,9 +20,7 @@ func Ptr[T any](t T) *T {
 func HandleListProducts(rw http.ResponseWriter, r *http.Request) {
 	rw.Header().Set("Content-Type", "application/json")
 
-	scopes, scopesFound := middleware.OAuth2Scopes(r)
-	if !scopesFound || !scopes.Has([]string{"products:read"}) {
-		http.Error(rw, `{"error": "insufficient scopes"}`, http.StatusForbidden)
+	if !middleware.RequireScopes(rw, r, "products:read") {
 		return
 	}
 
@@ -97,9 +95,7 @@ func HandleListProducts(rw http.ResponseWriter, r *http.Request) {
 func HandleCreateProduct(rw http.ResponseWriter, r *http.Request) {
 	rw.Header().Set("Content-Type", "application/json")
 
-	scopes, scopesFound := middleware.OAuth2Scopes(r)
-	if !scopesFound || !scopes.Has([]string{"products:create"}) {
-		http.Error(rw, `{"error": "insufficient scopes"}`, http.StatusForbidden)
+	if !middleware.RequireScopes(rw, r, "products:create") {
 		return
 	}
 
@@ -132,9 +128,7 @@ func HandleCreateProduct(rw http.ResponseWriter, r *http.Request) {
 func HandleFetchProduct(rw http.ResponseWriter, r *http.Request) {
 	rw.Header().Set("Content-Type", "application/json")
 
-	scopes, scopesFound := middleware.OAuth2Scopes(r)
-	if !scopesFound || !scopes.Has([]string{"products:read"}) {
-		http.Error(rw, `{"error": "insufficient scopes"}`, http.StatusForbidden)
+	if !middleware.RequireScopes(rw, r, "products:read") {
 		return
 	}
 
@@ -178,10 +172,7 @@ func HandleFetchProduct(rw http.ResponseWriter, r *http.Request) {
 }
 
 func HandleDeleteProduct(rw http.ResponseWriter, r *http.Request) {
-	scopes, scopesFound := middleware.OAuth2Scopes(r)
-	if !scopesFound || !scopes.Has([]string{"products:delete"}) {
-		rw.Header().Set("Content-Type", "application/json")
-		http.Error(rw, `{"error": "insufficient scopes"}`, http.StatusForbidden)
+	if !middleware.RequireScopes(rw, r, "products:delete") {
 		return
 	}
 
@@ -204,9 +195,7 @@ func HandleDeleteProduct(rw http.ResponseWriter, r *http.Request) {
 func HandleUpdateProductStock(rw http.ResponseWriter, r *http.Request) {
 	rw.Header().Set("Content-Type", "application/json")
 
-	scopes, scopesFound := middleware.OAuth2Scopes(r)
-	if !scopesFound || !scopes.Has([]string{"admin"}) {
-		http.Error(rw, `{"error": "insufficient scopes"}`, http.StatusForbidden)
+	if !middleware.RequireScopes(rw, r, "admin") {
 		return
 	}
 
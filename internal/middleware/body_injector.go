@@ -0,0 +1,256 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/lingrino/go-fault"
+)
+
+// errBodyFault is returned from writes after a mid-body fault cut the
+// response off.
+var errBodyFault = errors.New("response cut off by injected fault")
+
+// BodyFault is what happens to a response once AfterBytes of its body have
+// been written.
+type BodyFault string
+
+const (
+	// BodyFaultTruncate closes the connection.
+	BodyFaultTruncate BodyFault = "truncate"
+	// BodyFaultReset resets the connection.
+	BodyFaultReset BodyFault = "reset"
+	// BodyFaultStall stops writing and holds the connection open until the
+	// client gives up.
+	BodyFaultStall BodyFault = "stall"
+)
+
+var _ fault.Injector = (*BodyFaultInjector)(nil)
+
+// BodyFaultInjector lets the handler start the response and then cuts it
+// off after AfterBytes of the body. With AfterBytes 0 only the headers are
+// sent.
+type BodyFaultInjector struct {
+	Fault      BodyFault
+	AfterBytes int
+}
+
+func (i *BodyFaultInjector) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bw := &bodyFaultWriter{ResponseWriter: w, r: r, injector: i}
+		next.ServeHTTP(bw, r)
+
+		// Responses ending exactly at AfterBytes, such as bodiless
+		// responses with AfterBytes 0, are cut off once the handler is done.
+		// Shorter responses complete normally.
+		if !bw.cut && bw.written >= i.AfterBytes {
+			bw.cutOff()
+		}
+	})
+}
+
+type bodyFaultWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	injector *BodyFaultInjector
+
+	written     int
+	wroteHeader bool
+	cut         bool
+}
+
+func (w *bodyFaultWriter) WriteHeader(status int) {
+	if w.wroteHeader || w.cut {
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *bodyFaultWriter) Write(p []byte) (int, error) {
+	if w.cut {
+		return 0, errBodyFault
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	remaining := w.injector.AfterBytes - w.written
+	if len(p) <= remaining {
+		n, err := w.ResponseWriter.Write(p)
+		w.written += n
+		return n, err
+	}
+
+	n, err := w.ResponseWriter.Write(p[:remaining])
+	w.written += n
+	if err != nil {
+		return n, err
+	}
+	w.cutOff()
+
+	return n, errBodyFault
+}
+
+func (w *bodyFaultWriter) Flush() {
+	if w.cut {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// cutOff sends what was written so far and applies the fault.
+func (w *bodyFaultWriter) cutOff() {
+	w.cut = true
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.ResponseWriter.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	switch w.injector.Fault {
+	case BodyFaultStall:
+		<-w.r.Context().Done()
+		return
+	case BodyFaultTruncate, BodyFaultReset:
+	default:
+		return
+	}
+
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok && w.injector.Fault == BodyFaultReset {
+		_ = tcpConn.SetLinger(0) // Best effort RST on close
+	}
+	conn.Close()
+}
+
+var _ fault.Injector = (*ContentLengthInjector)(nil)
+
+// ContentLengthInjector sends the response with a Content-Length header that
+// is off by Delta bytes from the body actually sent, then closes the
+// connection. The response is written directly to the connection, as
+// net/http refuses to send mismatched lengths.
+//
+// The headers are sent once the handler finishes or first flushes. Streaming
+// handlers that flush before finishing are passed through as they write, and
+// the declared length is that of the handler's Content-Length header or, if
+// it has none, of the body written before the first flush.
+type ContentLengthInjector struct {
+	Delta int
+}
+
+func (i *ContentLengthInjector) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &contentLengthWriter{
+			ResponseWriter: w,
+			proto:          r.Proto,
+			delta:          i.Delta,
+			header:         w.Header().Clone(),
+			status:         http.StatusOK,
+		}
+		next.ServeHTTP(cw, r)
+
+		if err := cw.commit(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = cw.conn.Flush()
+		cw.rawConn.Close()
+	})
+}
+
+// contentLengthWriter holds the response back until it is committed to the
+// hijacked connection, and writes through to the connection afterwards.
+type contentLengthWriter struct {
+	http.ResponseWriter
+	proto string
+	delta int
+
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+
+	rawConn net.Conn
+	conn    *bufio.Writer
+}
+
+func (w *contentLengthWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *contentLengthWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+	}
+}
+
+func (w *contentLengthWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.conn == nil {
+		return w.body.Write(p)
+	}
+
+	return w.conn.Write(p)
+}
+
+func (w *contentLengthWriter) Flush() {
+	if err := w.commit(); err == nil {
+		_ = w.conn.Flush()
+	}
+}
+
+// commit hijacks the connection and sends the headers, with the wrong
+// Content-Length, and the body written so far.
+func (w *contentLengthWriter) commit() error {
+	if w.conn != nil {
+		return nil
+	}
+
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return errors.New("connection hijacking not supported")
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return errors.New("failed to hijack connection")
+	}
+	w.rawConn, w.conn = conn, buf.Writer
+
+	length := w.body.Len()
+	if n, err := strconv.Atoi(w.header.Get("Content-Length")); err == nil {
+		length = n
+	}
+	w.header.Set("Content-Length", strconv.Itoa(max(0, length+w.delta)))
+	w.header.Del("Transfer-Encoding")
+	w.header.Set("Connection", "close")
+
+	proto := w.proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	fmt.Fprintf(w.conn, "%s %d %s\r\n", proto, w.status, http.StatusText(w.status))
+	_ = w.header.Write(w.conn)
+	_, _ = w.conn.WriteString("\r\n")
+	_, _ = w.conn.Write(w.body.Bytes())
+	w.body.Reset()
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lingrino/go-fault"
+)
+
+func TestBodyFaults(t *testing.T) {
+	const body = "hello, world"
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.Write([]byte(body))
+	})
+
+	tests := []struct {
+		name     string
+		injector fault.Injector
+		wantBody string
+		// complete is set when reading the body succeeds.
+		complete bool
+	}{
+		{
+			name:     "truncate",
+			injector: &BodyFaultInjector{Fault: BodyFaultTruncate, AfterBytes: 5},
+			wantBody: "hello",
+		},
+		{
+			name:     "reset",
+			injector: &BodyFaultInjector{Fault: BodyFaultReset, AfterBytes: 5},
+		},
+		{
+			name:     "stall after headers",
+			injector: &BodyFaultInjector{Fault: BodyFaultStall},
+		},
+		{
+			name:     "content length too long",
+			injector: &ContentLengthInjector{Delta: 10},
+			wantBody: body,
+		},
+		{
+			name:     "content length too short",
+			injector: &ContentLengthInjector{Delta: -5},
+			wantBody: "hello, ",
+			complete: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.injector.Handler(next))
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("headers were not received: %v", err)
+			}
+			defer res.Body.Close()
+
+			got, err := io.ReadAll(res.Body)
+			if tt.complete && err != nil {
+				t.Fatalf("reading the body failed: %v", err)
+			}
+			if !tt.complete && err == nil {
+				t.Fatalf("reading the body succeeded with %q", got)
+			}
+			if tt.wantBody != "" && string(got) != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestContentLengthInjectorStreams(t *testing.T) {
+	release := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("data: second\n\n"))
+	})
+
+	srv := httptest.NewServer((&ContentLengthInjector{Delta: 10}).Handler(next))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+
+	// The headers and the first event arrive while the handler still runs.
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("headers were not received: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.ContentLength != int64(len("data: first\n\n")+10) {
+		t.Errorf("Content-Length = %d, want the first event plus 10", res.ContentLength)
+	}
+	first := make([]byte, len("data: first\n\n"))
+	if _, err := io.ReadFull(res.Body, first); err != nil {
+		t.Fatalf("first event was not streamed: %v", err)
+	}
+}
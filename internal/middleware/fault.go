@@ -15,6 +15,8 @@ type FaultSession struct {
 	// Exhausted is set to true when all faults on this session have been exercised.
 	Exhausted bool
 	Settings  FaultSettings
+
+	mu sync.Mutex
 }
 
 // Describes the fault injection settings for a session. The fault chain is
@@ -24,7 +26,24 @@ type FaultSession struct {
 // - Reset
 // - Reject
 // - Error
+//
+// Alternatively, Plan scripts the session request by request, or Chaos
+// injects faults at random, and the other settings are ignored.
 type FaultSettings struct {
+	// Plan is the ordered list of steps applied to the requests of the
+	// session, one step per request. Requests after the last step pass
+	// through. The plan is fixed by the first request of the session, later
+	// requests only need the request-id header.
+	Plan []FaultStep `json:"plan,omitempty"`
+
+	// Throttle slows down every request of the session, on top of its other
+	// faults.
+	Throttle *ThrottleSettings `json:"throttle,omitempty"`
+
+	// Chaos injects random faults into every request of the session. Like
+	// the plan, it is fixed by the first request of the session.
+	Chaos *ChaosSettings `json:"chaos,omitempty"`
+
 	// DelayMS is the number of milliseconds to delay the request.
 	DelayMS int64 `json:"delay_ms"`
 
@@ -49,31 +68,44 @@ type FaultSettings struct {
 	ErrorCode int `json:"error_code"`
 }
 
+// faultSessions maps request IDs, and the IDs of registered fault rules, to
+// their *FaultSession.
+var faultSessions sync.Map
+
 func Fault(h http.Handler) http.Handler {
-	var sessions sync.Map
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		reqid := r.Header.Get("request-id")
 		if reqid == "" {
-			h.ServeHTTP(w, r)
-			return
-		}
+			// Requests without a session of their own may match a fault rule
+			// registered through the admin API.
+			if rule, ok := matchFaultRule(r); ok {
+				serveFaults(w, r, h, rule.session)
+				return
+			}
 
-		settinghdr := r.Header.Get("fault-settings")
-		if settinghdr == "" {
 			h.ServeHTTP(w, r)
 			return
 		}
 
 		session := &FaultSession{}
-		asession, found := sessions.Load(reqid)
+		asession, found := faultSessions.Load(reqid)
 		if found {
 			session = asession.(*FaultSession)
-			if session.Exhausted {
-				h.ServeHTTP(w, r)
+			session.mu.Lock()
+			fixed := len(session.Settings.Plan) > 0 || session.Settings.Chaos != nil
+			session.mu.Unlock()
+			if fixed {
+				serveFaults(w, r, h, session)
 				return
 			}
 		}
 
+		settinghdr := r.Header.Get("fault-settings")
+		if settinghdr == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
 		var settings FaultSettings
 		err := json.Unmarshal([]byte(settinghdr), &settings)
 		if err != nil {
@@ -81,71 +113,102 @@ func Fault(h http.Handler) http.Handler {
 			return
 		}
 
-		reqCount := session.RequestCount
+		// Count based settings are re-read from every request.
+		session.mu.Lock()
 		session.Settings = settings
-		session.RequestCount++
-		defer func() {
-			sessions.Store(reqid, session)
-		}()
-
-		var faults []fault.Injector
+		session.mu.Unlock()
+		asession, _ = faultSessions.LoadOrStore(reqid, session)
 
-		// Since multiple injectors can be enabled, need to count the number of
-		// requests based on prior injector counts
-		countOffset := 0
-
-		if settings.DelayMS > 0 && reqCount < settings.DelayCount {
-			inj, err := fault.NewSlowInjector(time.Millisecond * time.Duration(settings.DelayMS))
-			if err != nil {
-				http.Error(w, "Failed to build slow injector", http.StatusInternalServerError)
-				return
-			}
+		serveFaults(w, r, h, asession.(*FaultSession))
+	})
+}
 
-			faults = append(faults, inj)
+// serveFaults applies the faults of the session settings to r.
+func serveFaults(w http.ResponseWriter, r *http.Request, h http.Handler, session *FaultSession) {
+	session.mu.Lock()
+	settings := session.Settings
+	if settings.Throttle != nil {
+		h = (&ThrottleInjector{Settings: *settings.Throttle}).Handler(h)
+	}
+	if len(settings.Plan) > 0 {
+		session.mu.Unlock()
+		servePlanStep(w, r, h, session)
+		return
+	}
+	if settings.Chaos != nil {
+		session.mu.Unlock()
+		serveChaos(w, r, h, session)
+		return
+	}
+	if session.Exhausted {
+		session.mu.Unlock()
+		h.ServeHTTP(w, r)
+		return
+	}
+	reqCount := session.RequestCount
+	session.RequestCount++
+	session.mu.Unlock()
+
+	var faults []fault.Injector
+
+	// Since multiple injectors can be enabled, need to count the number of
+	// requests based on prior injector counts
+	countOffset := 0
+
+	if settings.DelayMS > 0 && reqCount < settings.DelayCount {
+		inj, err := fault.NewSlowInjector(time.Millisecond * time.Duration(settings.DelayMS))
+		if err != nil {
+			http.Error(w, "Failed to build slow injector", http.StatusInternalServerError)
+			return
 		}
 
-		// Delay injector does not increase the count offset.
+		faults = append(faults, inj)
+	}
 
-		if settings.ResetCount > 0 && reqCount < settings.ResetCount+countOffset {
-			faults = append(faults, &ConnectionResetInjector{})
-		}
+	// Delay injector does not increase the count offset.
 
-		countOffset += settings.ResetCount
+	if settings.ResetCount > 0 && reqCount < settings.ResetCount+countOffset {
+		faults = append(faults, &ConnectionResetInjector{})
+	}
 
-		if settings.RejectCount > 0 && reqCount < settings.RejectCount+countOffset {
-			inj, err := fault.NewRejectInjector()
-			if err != nil {
-				http.Error(w, "Failed to build reject injector", http.StatusInternalServerError)
-				return
-			}
+	countOffset += settings.ResetCount
 
-			faults = append(faults, inj)
+	if settings.RejectCount > 0 && reqCount < settings.RejectCount+countOffset {
+		inj, err := fault.NewRejectInjector()
+		if err != nil {
+			http.Error(w, "Failed to build reject injector", http.StatusInternalServerError)
+			return
 		}
 
-		countOffset += settings.RejectCount
+		faults = append(faults, inj)
+	}
 
-		if settings.ErrorCode > 0 && reqCount < (settings.ErrorCount+countOffset) {
-			inj, err := fault.NewErrorInjector(settings.ErrorCode, fault.WithStatusText("Injected error"))
-			if err != nil {
-				http.Error(w, "Failed to build error injector", http.StatusInternalServerError)
-				return
-			}
-
-			faults = append(faults, inj)
-		}
-
-		if len(faults) == 0 {
-			session.Exhausted = true
-			h.ServeHTTP(w, r)
-			return
-		}
+	countOffset += settings.RejectCount
 
-		faultchain, err := fault.NewChainInjector(faults)
+	if settings.ErrorCode > 0 && reqCount < (settings.ErrorCount+countOffset) {
+		inj, err := fault.NewErrorInjector(settings.ErrorCode, fault.WithStatusText("Injected error"))
 		if err != nil {
-			http.Error(w, "Failed to build fault chain injector", http.StatusInternalServerError)
+			http.Error(w, "Failed to build error injector", http.StatusInternalServerError)
+			return
 		}
 
-		w.Header().Set("Faults-Enabled", "true")
-		faultchain.Handler(h).ServeHTTP(w, r)
-	})
+		faults = append(faults, inj)
+	}
+
+	if len(faults) == 0 {
+		session.mu.Lock()
+		session.Exhausted = true
+		session.mu.Unlock()
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	faultchain, err := fault.NewChainInjector(faults)
+	if err != nil {
+		http.Error(w, "Failed to build fault chain injector", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Faults-Enabled", "true")
+	faultchain.Handler(h).ServeHTTP(w, r)
 }
@@ -0,0 +1,304 @@
+package middleware
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/speakeasy-api/speakeasy-api-test-service/internal/auth"
+)
+
+// FaultRule applies fault settings to requests that do not carry a
+// request-id header, for clients that cannot be made to send one. Empty
+// criteria match every request; all requests matching a rule share its
+// session.
+type FaultRule struct {
+	ID string `json:"id"`
+
+	// Path is a path.Match pattern of the request path, e.g. /retries or
+	// /pagination/*.
+	Path string `json:"path,omitempty"`
+
+	// Method is the request method.
+	Method string `json:"method,omitempty"`
+
+	// UserAgent must be contained in the User-Agent header.
+	UserAgent string `json:"user_agent,omitempty"`
+
+	// RemoteAddr is the IP address of the client.
+	RemoteAddr string `json:"remote_addr,omitempty"`
+
+	// ClientID is the client_id query parameter, Basic auth username, API
+	// key or the client of the bearer token of the request. See
+	// requestClientIDs.
+	ClientID string `json:"client_id,omitempty"`
+
+	Settings FaultSettings `json:"settings"`
+
+	session *FaultSession
+}
+
+func (rule *FaultRule) matches(r *http.Request) bool {
+	if rule.Path != "" {
+		if ok, _ := path.Match(rule.Path, r.URL.Path); !ok {
+			return false
+		}
+	}
+	if rule.Method != "" && !strings.EqualFold(rule.Method, r.Method) {
+		return false
+	}
+	if rule.UserAgent != "" && !strings.Contains(r.UserAgent(), rule.UserAgent) {
+		return false
+	}
+	if rule.RemoteAddr != "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if host != rule.RemoteAddr {
+			return false
+		}
+	}
+	if rule.ClientID != "" && !slices.Contains(requestClientIDs(r), rule.ClientID) {
+		return false
+	}
+
+	return true
+}
+
+// requestClientIDs returns the identifiers a request presents for its client,
+// without reading the request body.
+func requestClientIDs(r *http.Request) []string {
+	var ids []string
+	if id := r.URL.Query().Get("client_id"); id != "" {
+		ids = append(ids, id)
+	}
+	if username, _, ok := r.BasicAuth(); ok {
+		ids = append(ids, username)
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		ids = append(ids, key)
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if claims, err := auth.ParseToken(token); err == nil {
+			if clientID, ok := claims["clientID"].(string); ok {
+				ids = append(ids, clientID)
+			}
+		}
+	}
+
+	return ids
+}
+
+// faultRules are checked in the order they were registered.
+var faultRules struct {
+	mu    sync.RWMutex
+	rules []*FaultRule
+}
+
+// faultRuleSessionPrefix prefixes the session IDs of fault rules.
+const faultRuleSessionPrefix = "rule:"
+
+// matchFaultRule returns the first rule matching r. The admin API is never
+// faulted so that rules can always be removed again.
+func matchFaultRule(r *http.Request) (*FaultRule, bool) {
+	if strings.HasPrefix(r.URL.Path, "/admin/") {
+		return nil, false
+	}
+
+	faultRules.mu.RLock()
+	defer faultRules.mu.RUnlock()
+
+	for _, rule := range faultRules.rules {
+		if rule.matches(r) {
+			return rule, true
+		}
+	}
+
+	return nil, false
+}
+
+// RegisterFaultRule adds a fault rule or replaces the rule with the same ID,
+// starting a new session for it. It returns the rule as registered, with the
+// chaos seed filled in.
+func RegisterFaultRule(rule FaultRule) FaultRule {
+	if rule.Settings.Chaos != nil {
+		// The seed is chosen up front so that the rule reports it.
+		chaos := *rule.Settings.Chaos
+		if chaos.Seed == 0 {
+			chaos.Seed = rand.Int63()
+		}
+		rule.Settings.Chaos = &chaos
+	}
+	rule.session = &FaultSession{Settings: rule.Settings}
+
+	// The session is stored under the lock so that concurrent registrations
+	// of the same ID leave the rule and its session matching.
+	faultRules.mu.Lock()
+	defer faultRules.mu.Unlock()
+
+	faultSessions.Store(faultRuleSessionPrefix+rule.ID, rule.session)
+
+	idx := slices.IndexFunc(faultRules.rules, func(r *FaultRule) bool { return r.ID == rule.ID })
+	if idx >= 0 {
+		faultRules.rules[idx] = &rule
+	} else {
+		faultRules.rules = append(faultRules.rules, &rule)
+	}
+
+	return rule
+}
+
+// DeleteFaultRule removes a fault rule and its session.
+func DeleteFaultRule(id string) bool {
+	faultRules.mu.Lock()
+	defer faultRules.mu.Unlock()
+
+	idx := slices.IndexFunc(faultRules.rules, func(r *FaultRule) bool { return r.ID == id })
+	if idx < 0 {
+		return false
+	}
+	faultRules.rules = slices.Delete(faultRules.rules, idx, idx+1)
+	faultSessions.Delete(faultRuleSessionPrefix + id)
+
+	return true
+}
+
+// resetFaultSession starts the session over. Sessions of request IDs are
+// forgotten, sessions of rules restart their settings.
+func resetFaultSession(id string) bool {
+	v, ok := faultSessions.Load(id)
+	if !ok {
+		return false
+	}
+
+	if !strings.HasPrefix(id, faultRuleSessionPrefix) {
+		faultSessions.Delete(id)
+		return true
+	}
+
+	session := v.(*FaultSession)
+	session.mu.Lock()
+	session.RequestCount = 0
+	session.Exhausted = false
+	session.mu.Unlock()
+
+	return true
+}
+
+type FaultSessionResponse struct {
+	ID           string        `json:"id"`
+	RequestCount int           `json:"request_count"`
+	Exhausted    bool          `json:"exhausted"`
+	Settings     FaultSettings `json:"settings"`
+}
+
+func faultSessionResponse(id string, session *FaultSession) FaultSessionResponse {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	return FaultSessionResponse{
+		ID:           id,
+		RequestCount: session.RequestCount,
+		Exhausted:    session.Exhausted,
+		Settings:     session.Settings,
+	}
+}
+
+func writeFaultJSON(w http.ResponseWriter, status int, v any) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, `{"error": "failed to encode response"}`, http.StatusInternalServerError)
+	}
+}
+
+func HandleListFaultSessions(w http.ResponseWriter, r *http.Request) {
+	res := []FaultSessionResponse{}
+	faultSessions.Range(func(key, value any) bool {
+		res = append(res, faultSessionResponse(key.(string), value.(*FaultSession)))
+		return true
+	})
+	slices.SortFunc(res, func(a, b FaultSessionResponse) int { return strings.Compare(a.ID, b.ID) })
+
+	writeFaultJSON(w, http.StatusOK, res)
+}
+
+func HandleGetFaultSession(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	v, ok := faultSessions.Load(id)
+	if !ok {
+		http.Error(w, `{"error": "fault session not found"}`, http.StatusNotFound)
+		return
+	}
+
+	writeFaultJSON(w, http.StatusOK, faultSessionResponse(id, v.(*FaultSession)))
+}
+
+// HandleResetFaultSession resets the session in the path, or every session
+// when the path has none.
+func HandleResetFaultSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		faultSessions.Range(func(key, _ any) bool {
+			resetFaultSession(key.(string))
+			return true
+		})
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !resetFaultSession(id) {
+		http.Error(w, `{"error": "fault session not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func HandleListFaultRules(w http.ResponseWriter, r *http.Request) {
+	faultRules.mu.RLock()
+	res := make([]FaultRule, 0, len(faultRules.rules))
+	for _, rule := range faultRules.rules {
+		res = append(res, *rule)
+	}
+	faultRules.mu.RUnlock()
+
+	writeFaultJSON(w, http.StatusOK, res)
+}
+
+// HandlePutFaultRule registers the fault rule in the request body under the
+// ID in the path. Replacing a rule restarts its session.
+func HandlePutFaultRule(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var rule FaultRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, `{"error": "invalid fault rule"}`, http.StatusBadRequest)
+		return
+	}
+	if _, err := path.Match(rule.Path, ""); err != nil {
+		http.Error(w, `{"error": "invalid path pattern"}`, http.StatusBadRequest)
+		return
+	}
+	rule.ID = mux.Vars(r)["id"]
+
+	writeFaultJSON(w, http.StatusOK, RegisterFaultRule(rule))
+}
+
+func HandleDeleteFaultRule(w http.ResponseWriter, r *http.Request) {
+	if !DeleteFaultRule(mux.Vars(r)["id"]) {
+		http.Error(w, `{"error": "fault rule not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
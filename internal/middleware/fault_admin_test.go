@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestFaultRules(t *testing.T) {
+	h := Fault(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/faults/rules/retries", strings.NewReader(`{"path": "/retries/*", "method": "GET", "settings": {"plan": ["503", "ok"]}}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "retries"})
+	w := httptest.NewRecorder()
+	HandlePutFaultRule(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandlePutFaultRule() status = %v: %s", w.Code, w.Body.String())
+	}
+	defer DeleteFaultRule("retries")
+
+	serve := func(method, path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+
+	if got := serve(http.MethodPost, "/retries/after"); got != http.StatusOK {
+		t.Errorf("unmatched method status = %v, want %v", got, http.StatusOK)
+	}
+	if got := serve(http.MethodGet, "/retries/after"); got != http.StatusServiceUnavailable {
+		t.Errorf("first matched request status = %v, want %v", got, http.StatusServiceUnavailable)
+	}
+	if got := serve(http.MethodGet, "/retries/after"); got != http.StatusOK {
+		t.Errorf("second matched request status = %v, want %v", got, http.StatusOK)
+	}
+
+	getSession := func() FaultSessionResponse {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/admin/faults/sessions/rule:retries", nil), map[string]string{"id": "rule:retries"})
+		w := httptest.NewRecorder()
+		HandleGetFaultSession(w, req)
+
+		var res FaultSessionResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	serve(http.MethodGet, "/retries/after")
+	if res := getSession(); res.RequestCount != 3 || !res.Exhausted {
+		t.Errorf("session = %+v, want 3 requests and exhausted", res)
+	}
+
+	req = mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/admin/faults/sessions/rule:retries", nil), map[string]string{"id": "rule:retries"})
+	HandleResetFaultSession(httptest.NewRecorder(), req)
+	if res := getSession(); res.RequestCount != 0 || res.Exhausted {
+		t.Errorf("session after reset = %+v", res)
+	}
+	if got := serve(http.MethodGet, "/retries/after"); got != http.StatusServiceUnavailable {
+		t.Errorf("request after reset status = %v, want %v", got, http.StatusServiceUnavailable)
+	}
+}
+
+func TestFaultRuleClientID(t *testing.T) {
+	rule := FaultRule{ClientID: "beezy"}
+
+	tests := []struct {
+		name  string
+		setup func(r *http.Request)
+		want  bool
+	}{
+		{name: "query parameter", setup: func(r *http.Request) { r.URL.RawQuery = "client_id=beezy" }, want: true},
+		{name: "basic auth", setup: func(r *http.Request) { r.SetBasicAuth("beezy", "super-secret") }, want: true},
+		{name: "api key", setup: func(r *http.Request) { r.Header.Set("X-API-Key", "beezy") }, want: true},
+		{name: "other client", setup: func(r *http.Request) { r.SetBasicAuth("other", "secret") }, want: false},
+		{name: "anonymous", setup: func(r *http.Request) {}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/retries", nil)
+			tt.setup(req)
+			if got := rule.matches(req); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFaultRuleChaosSeed(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPut, "/admin/faults/rules/chaos-seed", strings.NewReader(`{"settings": {"chaos": {"error_rate": 0.5}}}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "chaos-seed"})
+	w := httptest.NewRecorder()
+	HandlePutFaultRule(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HandlePutFaultRule() status = %v: %s", w.Code, w.Body.String())
+	}
+	defer DeleteFaultRule("chaos-seed")
+
+	var res FaultRule
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Settings.Chaos == nil || res.Settings.Chaos.Seed == 0 {
+		t.Fatalf("response did not report the chaos seed: %s", w.Body.String())
+	}
+
+	session, ok := faultSessions.Load(faultRuleSessionPrefix + "chaos-seed")
+	if !ok {
+		t.Fatal("rule session was not registered")
+	}
+	if got := session.(*FaultSession).Settings.Chaos.Seed; got != res.Settings.Chaos.Seed {
+		t.Errorf("session seed = %v, reported seed %v", got, res.Settings.Chaos.Seed)
+	}
+}
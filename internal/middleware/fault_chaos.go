@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lingrino/go-fault"
+)
+
+// z99 is the standard normal quantile of the 99th percentile.
+const z99 = 2.3263478740408408
+
+// ChaosSettings inject faults at random. Outcomes only depend on the seed and
+// the position of the request in its session, so a run can be replayed by
+// registering the seed reported in the x-chaos-seed header.
+type ChaosSettings struct {
+	// Seed of the random outcomes. A random seed is chosen when zero.
+	Seed int64 `json:"seed,omitempty"`
+
+	// ErrorRate is the probability of responding with ErrorCode.
+	ErrorRate float64 `json:"error_rate,omitempty"`
+
+	// ErrorCode is the status of injected errors, 503 by default.
+	ErrorCode int `json:"error_code,omitempty"`
+
+	// ResetRate is the probability of resetting the connection.
+	ResetRate float64 `json:"reset_rate,omitempty"`
+
+	// LatencyP50MS and LatencyP99MS are the median and 99th percentile of
+	// the added latency in milliseconds, which follows a log-normal
+	// distribution. Without P99 the latency is fixed at P50.
+	LatencyP50MS int64 `json:"latency_p50_ms,omitempty"`
+	LatencyP99MS int64 `json:"latency_p99_ms,omitempty"`
+}
+
+// latency samples the added latency.
+func (c ChaosSettings) latency(rng *rand.Rand) time.Duration {
+	if c.LatencyP50MS <= 0 {
+		return 0
+	}
+
+	ms := float64(c.LatencyP50MS)
+	if c.LatencyP99MS > c.LatencyP50MS {
+		sigma := math.Log(float64(c.LatencyP99MS)/float64(c.LatencyP50MS)) / z99
+		ms *= math.Exp(sigma * rng.NormFloat64())
+	}
+
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// chaosRand returns the random source of the nth request of a session.
+func chaosRand(seed int64, n int) *rand.Rand {
+	return rand.New(rand.NewSource(seed ^ int64(n)*0x5851f42d4c957f2d))
+}
+
+// serveChaos applies random faults of the session chaos settings to r.
+func serveChaos(w http.ResponseWriter, r *http.Request, h http.Handler, session *FaultSession) {
+	session.mu.Lock()
+	if session.Settings.Chaos.Seed == 0 {
+		session.Settings.Chaos.Seed = rand.Int63()
+	}
+	chaos := *session.Settings.Chaos
+	n := session.RequestCount
+	session.RequestCount++
+	session.mu.Unlock()
+
+	w.Header().Set("x-chaos-seed", strconv.FormatInt(chaos.Seed, 10))
+	w.Header().Set("x-chaos-request", strconv.Itoa(n))
+
+	// Every value is drawn for every request, so that changing one rate does
+	// not shift the outcomes of the others.
+	rng := chaosRand(chaos.Seed, n)
+	delay := chaos.latency(rng)
+	reset := rng.Float64() < chaos.ResetRate
+	failed := rng.Float64() < chaos.ErrorRate
+
+	var faults []fault.Injector
+	if delay > 0 {
+		inj, err := fault.NewSlowInjector(delay)
+		if err != nil {
+			http.Error(w, "Failed to build slow injector", http.StatusInternalServerError)
+			return
+		}
+		faults = append(faults, inj)
+	}
+
+	switch {
+	case reset:
+		faults = append(faults, &ConnectionResetInjector{})
+	case failed:
+		code := chaos.ErrorCode
+		if code == 0 {
+			code = http.StatusServiceUnavailable
+		}
+		faults = append(faults, &ResponseInjector{Status: code})
+	}
+
+	if len(faults) == 0 {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	faultchain, err := fault.NewChainInjector(faults)
+	if err != nil {
+		http.Error(w, "Failed to build fault chain injector", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Faults-Enabled", "true")
+	faultchain.Handler(h).ServeHTTP(w, r)
+}
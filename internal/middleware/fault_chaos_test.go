@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestChaosLatency(t *testing.T) {
+	chaos := ChaosSettings{LatencyP50MS: 100, LatencyP99MS: 1000}
+
+	var samples []time.Duration
+	for n := range 20000 {
+		samples = append(samples, chaos.latency(chaosRand(42, n)))
+	}
+	slices.Sort(samples)
+
+	p50 := samples[len(samples)/2]
+	p99 := samples[len(samples)*99/100]
+	if math.Abs(float64(p50-100*time.Millisecond)) > float64(10*time.Millisecond) {
+		t.Errorf("p50 = %v, want about 100ms", p50)
+	}
+	if math.Abs(float64(p99-time.Second)) > float64(150*time.Millisecond) {
+		t.Errorf("p99 = %v, want about 1s", p99)
+	}
+}
+
+func TestChaosReproducible(t *testing.T) {
+	h := Fault(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	run := func(session string) ([]int, string) {
+		faultSessions.Delete(session)
+		settings, _ := json.Marshal(FaultSettings{Chaos: &ChaosSettings{Seed: 7, ErrorRate: 0.5, ErrorCode: http.StatusBadGateway}})
+
+		var statuses []int
+		var seed string
+		for range 20 {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("request-id", session)
+			req.Header.Set("fault-settings", string(settings))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			statuses = append(statuses, w.Code)
+			seed = w.Header().Get("x-chaos-seed")
+		}
+		return statuses, seed
+	}
+
+	first, seed := run("chaos-a")
+	second, _ := run("chaos-b")
+	if seed != "7" {
+		t.Errorf("x-chaos-seed = %q, want 7", seed)
+	}
+	if !slices.Equal(first, second) {
+		t.Errorf("runs with the same seed differ: %v and %v", first, second)
+	}
+	if !slices.Contains(first, http.StatusOK) || !slices.Contains(first, http.StatusBadGateway) {
+		t.Errorf("expected a mix of successes and errors, got %v", first)
+	}
+}
@@ -0,0 +1,286 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lingrino/go-fault"
+)
+
+// FaultStep is one request of a scripted fault plan. Its faults are applied
+// in the order delay, reset, reject, response. A step without reset, reject or
+// status passes the request on to the handler, after the delay if any. Body
+// faults then act on the response of the handler, or the injected response.
+//
+// Steps are JSON objects, or strings of "+" separated parts for brevity:
+//
+//	"ok"                    pass through
+//	"503"                   respond with status 503
+//	"reset" / "reject"      reset or drop the connection
+//	"delay 2s + 200"        wait two seconds, then respond with status 200
+//	"429 + retry-after 1"   respond 429 with a Retry-After: 1 header
+//	"truncate 100"          close the connection after 100 body bytes
+//	"reset 100"             reset the connection after 100 body bytes
+//	"stall" / "stall 100"   stop sending after the headers or 100 body bytes
+//	"content-length -10"    declare a Content-Length 10 bytes short
+//	"throttle 1024"         send the response at 1024 bytes per second
+//	"chunk 16"              drip the response in flushed 16 byte chunks
+//	"jitter 50ms"           pause up to 50ms more after every chunk
+//	"throttle-request 512"  read the request body at 512 bytes per second
+type FaultStep struct {
+	// DelayMS is the number of milliseconds to wait before the rest of the
+	// step.
+	DelayMS int64 `json:"delay_ms,omitempty"`
+
+	// Reset resets the connection.
+	Reset bool `json:"reset,omitempty"`
+
+	// Reject drops the request without a response.
+	Reject bool `json:"reject,omitempty"`
+
+	// Status is the status code of an injected response.
+	Status int `json:"status,omitempty"`
+
+	// Headers are set on the injected response.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Body is the body of the injected response. It defaults to the status
+	// text.
+	Body string `json:"body,omitempty"`
+
+	// BodyFault cuts the response off after AfterBytes of the body.
+	BodyFault  BodyFault `json:"body_fault,omitempty"`
+	AfterBytes int       `json:"after_bytes,omitempty"`
+
+	// ContentLengthDelta, when not zero, is added to the Content-Length
+	// header without changing the body.
+	ContentLengthDelta int `json:"content_length_delta,omitempty"`
+
+	// Throttle slows down the request body and the response.
+	Throttle *ThrottleSettings `json:"throttle,omitempty"`
+}
+
+func (s *FaultStep) throttle() *ThrottleSettings {
+	if s.Throttle == nil {
+		s.Throttle = &ThrottleSettings{}
+	}
+
+	return s.Throttle
+}
+
+func (s *FaultStep) UnmarshalJSON(data []byte) error {
+	var shorthand any
+	if err := json.Unmarshal(data, &shorthand); err != nil {
+		return err
+	}
+
+	switch v := shorthand.(type) {
+	case string:
+		step, err := ParseFaultStep(v)
+		if err != nil {
+			return err
+		}
+		*s = step
+		return nil
+	case float64:
+		*s = FaultStep{Status: int(v)}
+		return nil
+	}
+
+	type faultStep FaultStep
+	return json.Unmarshal(data, (*faultStep)(s))
+}
+
+// ParseFaultStep parses the string form of a fault step.
+func ParseFaultStep(spec string) (FaultStep, error) {
+	var step FaultStep
+	for _, part := range strings.Split(spec, "+") {
+		fields := strings.Fields(strings.ToLower(part))
+		if len(fields) == 0 {
+			return step, fmt.Errorf("fault step %q: empty part", spec)
+		}
+
+		switch {
+		case len(fields) == 1 && fields[0] == "ok":
+		case len(fields) == 1 && fields[0] == "reset":
+			step.Reset = true
+		case len(fields) == 1 && fields[0] == "reject":
+			step.Reject = true
+		case len(fields) == 2 && fields[0] == "delay":
+			d, err := time.ParseDuration(fields[1])
+			if err != nil {
+				return step, fmt.Errorf("fault step %q: %w", spec, err)
+			}
+			step.DelayMS = d.Milliseconds()
+		case (len(fields) == 1 || len(fields) == 2) && fields[0] == "stall",
+			len(fields) == 2 && (fields[0] == "truncate" || fields[0] == "reset"):
+			step.BodyFault = BodyFault(fields[0])
+			if len(fields) == 2 {
+				n, err := strconv.Atoi(fields[1])
+				if err != nil || n < 0 {
+					return step, fmt.Errorf("fault step %q: invalid byte count %q", spec, fields[1])
+				}
+				step.AfterBytes = n
+			}
+		case len(fields) == 2 && fields[0] == "content-length":
+			delta, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return step, fmt.Errorf("fault step %q: invalid content length delta %q", spec, fields[1])
+			}
+			step.ContentLengthDelta = delta
+		case len(fields) == 2 && (fields[0] == "throttle" || fields[0] == "chunk" || fields[0] == "throttle-request"):
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n <= 0 {
+				return step, fmt.Errorf("fault step %q: invalid byte count %q", spec, fields[1])
+			}
+			switch fields[0] {
+			case "throttle":
+				step.throttle().BytesPerSecond = n
+			case "chunk":
+				step.throttle().ChunkBytes = n
+			default:
+				step.throttle().RequestBytesPerSecond = n
+			}
+		case len(fields) == 2 && fields[0] == "jitter":
+			d, err := time.ParseDuration(fields[1])
+			if err != nil {
+				return step, fmt.Errorf("fault step %q: %w", spec, err)
+			}
+			step.throttle().JitterMS = d.Milliseconds()
+		case len(fields) == 2 && fields[0] == "retry-after":
+			if step.Headers == nil {
+				step.Headers = map[string]string{}
+			}
+			step.Headers["Retry-After"] = fields[1]
+		case len(fields) == 1:
+			status, err := strconv.Atoi(fields[0])
+			if err != nil || status < 100 || status > 599 {
+				return step, fmt.Errorf("fault step %q: unknown part %q", spec, part)
+			}
+			step.Status = status
+		default:
+			return step, fmt.Errorf("fault step %q: unknown part %q", spec, part)
+		}
+	}
+
+	return step, nil
+}
+
+// injectors returns the fault injectors of the step.
+func (s FaultStep) injectors() ([]fault.Injector, error) {
+	var faults []fault.Injector
+
+	if s.DelayMS > 0 {
+		inj, err := fault.NewSlowInjector(time.Millisecond * time.Duration(s.DelayMS))
+		if err != nil {
+			return nil, err
+		}
+		faults = append(faults, inj)
+	}
+
+	if s.Reset {
+		faults = append(faults, &ConnectionResetInjector{})
+	}
+
+	if s.Reject {
+		inj, err := fault.NewRejectInjector()
+		if err != nil {
+			return nil, err
+		}
+		faults = append(faults, inj)
+	}
+
+	if s.ContentLengthDelta != 0 {
+		faults = append(faults, &ContentLengthInjector{Delta: s.ContentLengthDelta})
+	}
+
+	if s.Throttle != nil {
+		faults = append(faults, &ThrottleInjector{Settings: *s.Throttle})
+	}
+
+	switch s.BodyFault {
+	case "":
+	case BodyFaultTruncate, BodyFaultReset, BodyFaultStall:
+		faults = append(faults, &BodyFaultInjector{Fault: s.BodyFault, AfterBytes: s.AfterBytes})
+	default:
+		return nil, fmt.Errorf("unknown body fault %q", s.BodyFault)
+	}
+
+	if s.Status > 0 {
+		faults = append(faults, &ResponseInjector{Status: s.Status, Headers: s.Headers, Body: s.Body})
+	}
+
+	return faults, nil
+}
+
+var _ fault.Injector = (*ResponseInjector)(nil)
+
+// ResponseInjector responds with a fixed status, headers and body instead of
+// calling the handler.
+type ResponseInjector struct {
+	Status  int
+	Headers map[string]string
+	Body    string
+}
+
+func (i *ResponseInjector) Handler(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range i.Headers {
+			w.Header().Set(k, v)
+		}
+
+		body := i.Body
+		if body == "" {
+			body = http.StatusText(i.Status)
+		}
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+
+		w.WriteHeader(i.Status)
+		_, _ = w.Write([]byte(body))
+	})
+}
+
+// servePlanStep serves r with the next step of the session plan, or passes it
+// to h once the plan is exhausted.
+func servePlanStep(w http.ResponseWriter, r *http.Request, h http.Handler, session *FaultSession) {
+	session.mu.Lock()
+	idx := session.RequestCount
+	session.RequestCount++
+	plan := session.Settings.Plan
+	if idx >= len(plan) {
+		session.Exhausted = true
+	}
+	session.mu.Unlock()
+
+	if idx >= len(plan) {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	faults, err := plan[idx].injectors()
+	if err != nil {
+		http.Error(w, "Failed to build fault step", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Fault-Step", strconv.Itoa(idx))
+	if len(faults) == 0 {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	faultchain, err := fault.NewChainInjector(faults)
+	if err != nil {
+		http.Error(w, "Failed to build fault chain injector", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Faults-Enabled", "true")
+	faultchain.Handler(h).ServeHTTP(w, r)
+}
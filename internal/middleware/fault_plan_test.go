@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseFaultStep(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    FaultStep
+		wantErr bool
+	}{
+		{spec: "ok", want: FaultStep{}},
+		{spec: "503", want: FaultStep{Status: 503}},
+		{spec: "reset", want: FaultStep{Reset: true}},
+		{spec: "delay 2s + 200", want: FaultStep{DelayMS: 2000, Status: 200}},
+		{spec: "429 + Retry-After 1", want: FaultStep{Status: 429, Headers: map[string]string{"Retry-After": "1"}}},
+		{spec: "truncate 100", want: FaultStep{BodyFault: BodyFaultTruncate, AfterBytes: 100}},
+		{spec: "reset 100", want: FaultStep{BodyFault: BodyFaultReset, AfterBytes: 100}},
+		{spec: "stall", want: FaultStep{BodyFault: BodyFaultStall}},
+		{spec: "content-length -10", want: FaultStep{ContentLengthDelta: -10}},
+		{spec: "throttle 1024 + chunk 16 + jitter 50ms", want: FaultStep{Throttle: &ThrottleSettings{BytesPerSecond: 1024, ChunkBytes: 16, JitterMS: 50}}},
+		{spec: "throttle-request 512", want: FaultStep{Throttle: &ThrottleSettings{RequestBytesPerSecond: 512}}},
+		{spec: "truncate", wantErr: true},
+		{spec: "throttle 0", wantErr: true},
+		{spec: "teapot", wantErr: true},
+		{spec: "999", wantErr: true},
+		{spec: "delay soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseFaultStep(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFaultStep() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFaultStep() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFaultPlan(t *testing.T) {
+	faultSessions.Delete("fault-plan-test")
+
+	srv := httptest.NewServer(Fault(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	defer srv.Close()
+
+	settings, err := json.Marshal(map[string]any{
+		"plan": []any{"ok", 503, "reset", map[string]any{"status": 429, "headers": map[string]string{"Retry-After": "1"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fresh connections stop the transport from retrying the reset request.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	wantStatuses := []int{http.StatusOK, http.StatusServiceUnavailable, 0, http.StatusTooManyRequests, http.StatusOK}
+	for i, want := range wantStatuses {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("request-id", "fault-plan-test")
+		if i == 0 {
+			req.Header.Set("fault-settings", string(settings))
+		}
+
+		res, err := client.Do(req)
+		if want == 0 {
+			if err == nil {
+				res.Body.Close()
+				t.Errorf("request %d: expected a connection error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != want {
+			t.Errorf("request %d: status = %v, want %v", i, res.StatusCode, want)
+		}
+		if want == http.StatusTooManyRequests && res.Header.Get("Retry-After") != "1" {
+			t.Errorf("request %d: missing Retry-After", i)
+		}
+	}
+}
@@ -16,37 +16,58 @@ var oauth2ClaimsKey oauth2CtxKey = "oauth2Claims"
 
 func OAuth2(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-
-		authhdr := r.Header.Get("Authorization")
-		if !strings.HasPrefix(authhdr, "Bearer ") {
-			auth.SendOAuth2Error(w, auth.ErrCodeInvalidRequest, "missing bearer token")
-			return
-		}
-
-		claims, err := auth.ParseToken(authhdr[7:])
-		if err != nil {
-			auth.SendOAuth2Error(w, auth.ErrCodeInvalidRequest, err.Error())
-			return
-		}
-
-		if auth.IsTokenExpired(claims) {
-			w.Header().Set("Content-Type", "application/json")
-			http.Error(w, `{"error": "token has expired"}`, http.StatusUnauthorized)
-			return
-		}
-
-		if claims["grantType"].(string) == "refresh_token" {
-			auth.SendOAuth2Error(w, auth.ErrCodeInvalidRequest, "cannot use refresh token as access token")
+		claims, code, description := verifyBearerToken(r)
+		if claims == nil {
+			auth.SendBearerError(w, r, code, description, "")
 			return
 		}
 
 		w.Header().Set("x-oauth2", "pass")
-		ctx = context.WithValue(ctx, oauth2ClaimsKey, claims)
+		ctx := context.WithValue(r.Context(), oauth2ClaimsKey, claims)
 		h.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// verifyBearerToken returns the claims of a valid access token in the
+// Authorization header of r. Requests without a bearer token get an empty
+// error code, for a bare challenge (RFC 6750 section 3.1); every problem with
+// a presented token is invalid_token.
+func verifyBearerToken(r *http.Request) (jwt.MapClaims, auth.OAuth2ErrorCode, string) {
+	authhdr := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authhdr, "Bearer ") {
+		return nil, "", "missing bearer token"
+	}
+
+	claims, err := auth.ParseToken(authhdr[7:])
+	if err != nil {
+		return nil, auth.ErrCodeInvalidToken, err.Error()
+	}
+
+	// ID tokens and other JWTs signed by this server are not access tokens.
+	if _, ok := auth.TokenID(claims); !ok {
+		return nil, auth.ErrCodeInvalidToken, "not an access token"
+	}
+
+	if auth.IsTokenExpired(claims) {
+		return nil, auth.ErrCodeInvalidToken, "token has expired"
+	}
+
+	if auth.IsTokenRevoked(claims) {
+		return nil, auth.ErrCodeInvalidToken, "token has been revoked"
+	}
+
+	if grantType, _ := claims["grantType"].(string); grantType == "refresh_token" {
+		return nil, auth.ErrCodeInvalidToken, "cannot use refresh token as access token"
+	}
+
+	return claims, "", ""
+}
+
+func OAuth2Claims(r *http.Request) (jwt.MapClaims, bool) {
+	claims, ok := r.Context().Value(oauth2ClaimsKey).(jwt.MapClaims)
+	return claims, ok
+}
+
 func OAuth2Scopes(r *http.Request) (Scopes, bool) {
 	v := r.Context().Value(oauth2ClaimsKey)
 	if v == nil {
@@ -72,6 +93,20 @@ func OAuth2Scopes(r *http.Request) (Scopes, bool) {
 	return scopes, true
 }
 
+// RequireScopes reports whether the access token authorizing r was granted
+// every required scope. If not, it responds with an RFC 6750
+// insufficient_scope error naming the scopes the resource requires.
+func RequireScopes(w http.ResponseWriter, r *http.Request, required ...string) bool {
+	scopes, found := OAuth2Scopes(r)
+	if found && scopes.Has(required) {
+		return true
+	}
+
+	auth.SendBearerError(w, r, auth.ErrCodeInsufficientScope, "the access token is missing required scopes", strings.Join(required, " "))
+
+	return false
+}
+
 type Scopes []string
 
 func (s Scopes) Has(requiredScopes []string) bool {
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/speakeasy-api/speakeasy-api-test-service/internal/auth"
+)
+
+func TestOAuth2IDTokenAsBearerToken(t *testing.T) {
+	form := url.Values{}
+	form.Set("grant_type", "password")
+	form.Set("client_id", "beezy")
+	form.Set("client_secret", "super-secret")
+	form.Set("username", "testuser")
+	form.Set("password", "testpassword")
+	form.Set("scope", "openid")
+	req := httptest.NewRequest(http.MethodPost, "/oauth2/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	auth.HandleOAuth2(w, req)
+
+	var res auth.OAuth2TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.IDToken == "" {
+		t.Fatalf("token response is missing id_token: %s", w.Body.String())
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for name, h := range map[string]http.Handler{
+		"OAuth2":   OAuth2(ok),
+		"Security": Security(SecurityRequirement{"oauth2": nil})(ok),
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+res.IDToken)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %v, want %v", w.Code, http.StatusUnauthorized)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); !strings.Contains(got, `error="invalid_token"`) {
+				t.Errorf("WWW-Authenticate = %q, want invalid_token", got)
+			}
+		})
+	}
+}
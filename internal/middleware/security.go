@@ -0,0 +1,232 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/speakeasy-api/speakeasy-api-test-service/internal/auth"
+)
+
+// SecurityRequirement maps security scheme names to the scopes they must
+// grant. Every scheme of a requirement must pass, as in an OpenAPI security
+// requirement object.
+type SecurityRequirement map[string][]string
+
+// schemes returns the scheme names of the requirement in a stable order.
+func (req SecurityRequirement) schemes() []string {
+	names := make([]string, 0, len(req))
+	for name := range req {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func (req SecurityRequirement) String() string {
+	var parts []string
+	for _, name := range req.schemes() {
+		part := name
+		if scopes := req[name]; len(scopes) > 0 {
+			part += "(" + strings.Join(scopes, " ") + ")"
+		}
+		parts = append(parts, part)
+	}
+
+	return "[" + strings.Join(parts, " AND ") + "]"
+}
+
+// SecurityError is a failed security scheme. Status is 401 when credentials
+// are missing or invalid and 403 when they lack the required scopes.
+// Challenge, if set, is sent in WWW-Authenticate when no requirement passes.
+type SecurityError struct {
+	Scheme    string `json:"scheme"`
+	Status    int    `json:"-"`
+	Message   string `json:"message"`
+	Challenge string `json:"-"`
+}
+
+func (e *SecurityError) Error() string {
+	return e.Scheme + ": " + e.Message
+}
+
+// SecurityScheme authenticates r, returning it with any credentials stored
+// in its context.
+type SecurityScheme func(r *http.Request, scopes []string) (*http.Request, *SecurityError)
+
+var securitySchemes = sync.Map{}
+
+// RegisterSecurityScheme makes a scheme available to Security requirements.
+func RegisterSecurityScheme(name string, scheme SecurityScheme) {
+	securitySchemes.Store(name, scheme)
+}
+
+// apiKeys maps the API keys accepted by the apiKey scheme to their owners.
+var apiKeys = sync.Map{}
+
+func RegisterAPIKey(key, owner string) {
+	apiKeys.Store(key, owner)
+}
+
+func init() {
+	RegisterAPIKey("test-api-key", "testuser")
+
+	RegisterSecurityScheme("apiKey", apiKeyScheme)
+	RegisterSecurityScheme("basic", basicScheme)
+	RegisterSecurityScheme("oauth2", oauth2Scheme)
+	RegisterSecurityScheme("session", sessionScheme)
+}
+
+func apiKeyScheme(r *http.Request, _ []string) (*http.Request, *SecurityError) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return nil, &SecurityError{Status: http.StatusUnauthorized, Message: "missing X-API-Key header"}
+	}
+	if _, ok := apiKeys.Load(key); !ok {
+		return nil, &SecurityError{Status: http.StatusUnauthorized, Message: "unknown API key"}
+	}
+
+	return r, nil
+}
+
+func basicScheme(r *http.Request, _ []string) (*http.Request, *SecurityError) {
+	challenge := `Basic realm="speakeasy-api-test-service"`
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, &SecurityError{Status: http.StatusUnauthorized, Message: "missing basic credentials", Challenge: challenge}
+	}
+	if !auth.ValidUserCredentials(username, password) {
+		return nil, &SecurityError{Status: http.StatusUnauthorized, Message: "invalid username or password", Challenge: challenge}
+	}
+
+	return r, nil
+}
+
+func oauth2Scheme(r *http.Request, scopes []string) (*http.Request, *SecurityError) {
+	challenge := `Bearer realm="speakeasy-api-test-service"`
+
+	claims, code, description := verifyBearerToken(r)
+	if claims == nil {
+		if code != "" {
+			challenge += fmt.Sprintf(`, error="%s", error_description=%s`, code, auth.QuoteChallengeParam(description))
+		}
+		return nil, &SecurityError{Status: http.StatusUnauthorized, Message: description, Challenge: challenge}
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), oauth2ClaimsKey, claims))
+	if granted, _ := OAuth2Scopes(r); !granted.Has(scopes) {
+		challenge += fmt.Sprintf(`, error="%s", scope=%s`, auth.ErrCodeInsufficientScope, auth.QuoteChallengeParam(strings.Join(scopes, " ")))
+		return nil, &SecurityError{Status: http.StatusForbidden, Message: "the access token is missing required scopes", Challenge: challenge}
+	}
+
+	return r, nil
+}
+
+func sessionScheme(r *http.Request, _ []string) (*http.Request, *SecurityError) {
+	s, err := auth.LookupCookieSession(r)
+	if err != nil {
+		return nil, &SecurityError{Status: http.StatusUnauthorized, Message: err.Error()}
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+	default:
+		if err := auth.VerifyCSRF(r, s); err != nil {
+			return nil, &SecurityError{Status: http.StatusForbidden, Message: err.Error()}
+		}
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), cookieSessionKey, s)), nil
+}
+
+// checkRequirement applies every scheme of req to r.
+func checkRequirement(r *http.Request, req SecurityRequirement) (*http.Request, []*SecurityError) {
+	var failures []*SecurityError
+	for _, name := range req.schemes() {
+		v, ok := securitySchemes.Load(name)
+		if !ok {
+			failures = append(failures, &SecurityError{Scheme: name, Status: http.StatusInternalServerError, Message: "unknown security scheme"})
+			continue
+		}
+
+		next, err := v.(SecurityScheme)(r, req[name])
+		if err != nil {
+			err.Scheme = name
+			failures = append(failures, err)
+			continue
+		}
+		r = next
+	}
+
+	return r, failures
+}
+
+type securityFailure struct {
+	Requirement string           `json:"requirement"`
+	Errors      []*SecurityError `json:"errors"`
+}
+
+// Security allows requests that satisfy any of the requirements, evaluated in
+// order like the alternatives of an OpenAPI security array. The index of the
+// requirement that passed is reported in the x-security-requirement header
+// and its schemes in x-security-schemes. An empty requirement allows
+// anonymous access.
+//
+// When every requirement fails the response is 403 if some requirement only
+// failed for lacking scopes, 401 otherwise, with a challenge for each scheme
+// that has one and the failures of every requirement in the body.
+func Security(requirements ...SecurityRequirement) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var failures []securityFailure
+			status := http.StatusUnauthorized
+			var challenges []string
+
+			for i, req := range requirements {
+				next, errs := checkRequirement(r, req)
+				if len(errs) == 0 {
+					w.Header().Set("x-security-requirement", strconv.Itoa(i))
+					w.Header().Set("x-security-schemes", strings.Join(req.schemes(), ","))
+					h.ServeHTTP(w, next)
+					return
+				}
+
+				failures = append(failures, securityFailure{Requirement: req.String(), Errors: errs})
+
+				forbidden := true
+				for _, err := range errs {
+					if err.Status != http.StatusForbidden {
+						forbidden = false
+					}
+					if err.Challenge != "" && !slices.Contains(challenges, err.Challenge) {
+						challenges = append(challenges, err.Challenge)
+					}
+					if err.Status == http.StatusInternalServerError {
+						status = http.StatusInternalServerError
+					}
+				}
+				if forbidden && status != http.StatusInternalServerError {
+					status = http.StatusForbidden
+				}
+			}
+
+			for _, challenge := range challenges {
+				w.Header().Add("WWW-Authenticate", challenge)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(map[string]any{"error": "security requirements not met", "failures": failures})
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSecurity(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	multi := []SecurityRequirement{
+		{"apiKey": nil, "basic": nil},
+		{"oauth2": {"read"}},
+	}
+
+	tests := []struct {
+		name            string
+		requirements    []SecurityRequirement
+		setupRequest    func(r *http.Request)
+		wantStatus      int
+		wantRequirement string
+		wantChallenges  int
+	}{
+		{
+			name:         "api key and basic",
+			requirements: multi,
+			setupRequest: func(r *http.Request) {
+				r.Header.Set("X-API-Key", "test-api-key")
+				r.SetBasicAuth("testuser", "testpassword")
+			},
+			wantStatus:      http.StatusOK,
+			wantRequirement: "0",
+		},
+		{
+			name:         "api key without basic",
+			requirements: multi,
+			setupRequest: func(r *http.Request) {
+				r.Header.Set("X-API-Key", "test-api-key")
+			},
+			wantStatus:     http.StatusUnauthorized,
+			wantChallenges: 2,
+		},
+		{
+			name:         "invalid bearer token",
+			requirements: multi,
+			setupRequest: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer invalid")
+			},
+			wantStatus:     http.StatusUnauthorized,
+			wantChallenges: 2,
+		},
+		{
+			name:            "anonymous alternative",
+			requirements:    append(multi, SecurityRequirement{}),
+			setupRequest:    func(r *http.Request) {},
+			wantStatus:      http.StatusOK,
+			wantRequirement: "2",
+		},
+		{
+			name:         "unknown scheme",
+			requirements: []SecurityRequirement{{"unknown": nil}},
+			setupRequest: func(r *http.Request) {},
+			wantStatus:   http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/security/multi", nil)
+			tt.setupRequest(req)
+			w := httptest.NewRecorder()
+			Security(tt.requirements...)(ok).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if got := w.Header().Get("x-security-requirement"); got != tt.wantRequirement {
+				t.Errorf("x-security-requirement = %q, want %q", got, tt.wantRequirement)
+			}
+			if got := len(w.Header().Values("WWW-Authenticate")); got != tt.wantChallenges {
+				t.Errorf("got %d challenges, want %d", got, tt.wantChallenges)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/lingrino/go-fault"
+)
+
+// ThrottleSettings limit the throughput of a request and its response.
+type ThrottleSettings struct {
+	// BytesPerSecond limits the response throughput.
+	BytesPerSecond int `json:"bytes_per_second,omitempty"`
+
+	// ChunkBytes is the size of the chunks the response is dripped in, each
+	// flushed on its own. It defaults to a tenth of BytesPerSecond.
+	ChunkBytes int `json:"chunk_bytes,omitempty"`
+
+	// JitterMS adds a random pause of up to this many milliseconds after
+	// every chunk.
+	JitterMS int64 `json:"jitter_ms,omitempty"`
+
+	// RequestBytesPerSecond limits how fast the request body is read.
+	RequestBytesPerSecond int `json:"request_bytes_per_second,omitempty"`
+}
+
+func (s ThrottleSettings) chunkBytes() int {
+	if s.ChunkBytes > 0 {
+		return s.ChunkBytes
+	}
+
+	return max(1, s.BytesPerSecond/10)
+}
+
+// pause returns how long to wait after sending n bytes at bytesPerSecond.
+func pause(n, bytesPerSecond int) time.Duration {
+	if bytesPerSecond <= 0 {
+		return 0
+	}
+
+	return time.Duration(n) * time.Second / time.Duration(bytesPerSecond)
+}
+
+// sleep waits for d or until ctx is done, reporting whether it waited.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+var _ fault.Injector = (*ThrottleInjector)(nil)
+
+// ThrottleInjector slows down the request body and drips the response.
+type ThrottleInjector struct {
+	Settings ThrottleSettings
+}
+
+func (i *ThrottleInjector) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if i.Settings.RequestBytesPerSecond > 0 && r.Body != nil {
+			r.Body = &throttledReader{
+				ReadCloser:     r.Body,
+				ctx:            r.Context(),
+				bytesPerSecond: i.Settings.RequestBytesPerSecond,
+			}
+		}
+
+		if i.Settings.BytesPerSecond <= 0 && i.Settings.ChunkBytes <= 0 && i.Settings.JitterMS <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(&throttledWriter{ResponseWriter: w, ctx: r.Context(), settings: i.Settings}, r)
+	})
+}
+
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	settings ThrottleSettings
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	chunk := w.settings.chunkBytes()
+
+	written := 0
+	for len(p) > 0 {
+		n, err := w.ResponseWriter.Write(p[:min(chunk, len(p))])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		w.Flush()
+
+		wait := pause(n, w.settings.BytesPerSecond)
+		if w.settings.JitterMS > 0 {
+			wait += time.Duration(rand.Int63n(w.settings.JitterMS+1)) * time.Millisecond
+		}
+		if !sleep(w.ctx, wait) {
+			return written, w.ctx.Err()
+		}
+	}
+
+	return written, nil
+}
+
+func (w *throttledWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *throttledWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection hijacking not supported")
+	}
+
+	return hijacker.Hijack()
+}
+
+// throttledReader reads at most bytesPerSecond from the request body.
+type throttledReader struct {
+	io.ReadCloser
+	ctx            context.Context
+	bytesPerSecond int
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	// Reads are capped at a tenth of a second worth of bytes so that the
+	// rate holds however large the caller's buffer is.
+	n, err := r.ReadCloser.Read(p[:min(len(p), max(1, r.bytesPerSecond/10))])
+	if n > 0 && !sleep(r.ctx, pause(n, r.bytesPerSecond)) {
+		return n, r.ctx.Err()
+	}
+
+	return n, err
+}
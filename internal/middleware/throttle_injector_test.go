@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThrottleInjector(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 1000)
+
+	tests := []struct {
+		name        string
+		settings    ThrottleSettings
+		requestBody []byte
+		minDuration time.Duration
+	}{
+		{
+			name:        "response throughput",
+			settings:    ThrottleSettings{BytesPerSecond: 4000, ChunkBytes: 100},
+			minDuration: 200 * time.Millisecond,
+		},
+		{
+			name:        "chunk jitter",
+			settings:    ThrottleSettings{ChunkBytes: 500, JitterMS: 1},
+			minDuration: 0,
+		},
+		{
+			name:        "request body",
+			settings:    ThrottleSettings{RequestBytesPerSecond: 2000},
+			requestBody: payload[:500],
+			minDuration: 200 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chunks int
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, err := io.ReadAll(r.Body); err != nil {
+					t.Errorf("reading the request body: %v", err)
+				}
+				_, _ = w.Write(payload)
+			})
+			inj := &ThrottleInjector{Settings: tt.settings}
+
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.requestBody))
+			w := &flushCounter{ResponseRecorder: httptest.NewRecorder(), flushes: &chunks}
+
+			start := time.Now()
+			inj.Handler(next).ServeHTTP(w, req)
+			elapsed := time.Since(start)
+
+			if elapsed < tt.minDuration {
+				t.Errorf("took %v, want at least %v", elapsed, tt.minDuration)
+			}
+			if !bytes.Equal(w.Body.Bytes(), payload) {
+				t.Errorf("response body changed")
+			}
+			if tt.settings.ChunkBytes > 0 {
+				if want := len(payload) / tt.settings.ChunkBytes; chunks != want {
+					t.Errorf("flushed %d chunks, want %d", chunks, want)
+				}
+			}
+		})
+	}
+}
+
+type flushCounter struct {
+	*httptest.ResponseRecorder
+	flushes *int
+}
+
+func (w *flushCounter) Flush() {
+	*w.flushes++
+	w.ResponseRecorder.Flush()
+}
@@ -0,0 +1,30 @@
+package oidc
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/speakeasy-api/speakeasy-api-test-service/internal/auth"
+	"github.com/speakeasy-api/speakeasy-api-test-service/internal/middleware"
+)
+
+func HandleUserInfo(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	if !middleware.RequireScopes(rw, r, auth.ScopeOpenID) {
+		return
+	}
+
+	claims, _ := middleware.OAuth2Claims(r)
+	info, ok := auth.UserInfo(claims)
+	if !ok {
+		http.Error(rw, `{"error": "token is not associated with a user"}`, http.StatusForbidden)
+		return
+	}
+
+	enc := json.NewEncoder(rw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(info); err != nil {
+		http.Error(rw, `{"error": "could not encode response"}`, http.StatusInternalServerError)
+	}
+}
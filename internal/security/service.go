@@ -0,0 +1,43 @@
+package security
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/speakeasy-api/speakeasy-api-test-service/internal/middleware"
+)
+
+type SecuredResponse struct {
+	Requirement string `json:"requirement"`
+	Schemes     string `json:"schemes"`
+	Subject     string `json:"subject,omitempty"`
+}
+
+// HandleSecured echoes the security requirement the request satisfied, as
+// reported by middleware.Security, and the authenticated subject if any.
+func HandleSecured(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	res := SecuredResponse{
+		Requirement: rw.Header().Get("x-security-requirement"),
+		Schemes:     rw.Header().Get("x-security-schemes"),
+	}
+	if username, _, ok := r.BasicAuth(); ok {
+		res.Subject = username
+	}
+	if claims, ok := middleware.OAuth2Claims(r); ok {
+		res.Subject, _ = claims["clientID"].(string)
+		if username, _ := claims["username"].(string); username != "" {
+			res.Subject = username
+		}
+	}
+	if s, ok := middleware.CookieSessionFromContext(r); ok {
+		res.Subject = s.Username
+	}
+
+	enc := json.NewEncoder(rw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(res); err != nil {
+		http.Error(rw, `{"error": "could not encode response"}`, http.StatusInternalServerError)
+	}
+}
@@ -20,7 +20,65 @@ type BasicAuth struct {
 	Password string `json:"password"`
 }
 
+// APIKeyAuth is an API key passed in a query parameter or cookie.
+type APIKeyAuth struct {
+	Name          string `json:"name"`
+	ExpectedValue string `json:"expectedValue"`
+}
+
+type BearerAuth struct {
+	Token string `json:"token"`
+}
+
+// DigestAuth is RFC 7616 HTTP Digest authentication with qop=auth. Requests
+// without valid credentials are answered with a challenge carrying a fresh
+// nonce.
+type DigestAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Realm defaults to the service's realm.
+	Realm string `json:"realm,omitempty"`
+	// Algorithm is MD5 (the default) or SHA-256.
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+// HMACAuth is a signature over the request's method, path and body:
+// HMAC(secret, METHOD + "\n" + path + "\n" + body).
+type HMACAuth struct {
+	Secret string `json:"secret"`
+	// HeaderName defaults to X-Signature.
+	HeaderName string `json:"headerName,omitempty"`
+	// Algorithm is sha256 (the default), sha1 or sha512.
+	Algorithm string `json:"algorithm,omitempty"`
+	// Encoding of the signature, hex (the default) or base64.
+	Encoding string `json:"encoding,omitempty"`
+}
+
 type AuthRequest struct {
 	HeaderAuth []HeaderAuth `json:"headerAuth,omitempty"`
 	BasicAuth  *BasicAuth   `json:"basicAuth,omitempty"`
+	QueryAuth  []APIKeyAuth `json:"queryAuth,omitempty"`
+	CookieAuth []APIKeyAuth `json:"cookieAuth,omitempty"`
+	BearerAuth *BearerAuth  `json:"bearerAuth,omitempty"`
+	DigestAuth *DigestAuth  `json:"digestAuth,omitempty"`
+	HMACAuth   *HMACAuth    `json:"hmacAuth,omitempty"`
+}
+
+// CustomSecurityField is a credential a custom security scheme expects in a
+// header, query parameter or cookie. The value must equal Value, or match the
+// regular expression Pattern if set.
+type CustomSecurityField struct {
+	In      string `json:"in"`
+	Name    string `json:"name"`
+	Value   string `json:"value,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// CustomSecurityScheme is satisfied when every field matches, every scheme in
+// AllOf is satisfied and, if AnyOf is set, at least one scheme in AnyOf is.
+type CustomSecurityScheme struct {
+	Name   string                `json:"name"`
+	Fields []CustomSecurityField `json:"fields,omitempty"`
+	AllOf  []string              `json:"allOf,omitempty"`
+	AnyOf  []string              `json:"anyOf,omitempty"`
 }